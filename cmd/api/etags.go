@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"greenlight.natenine.com/internal/data"
+)
+
+// The movieETag() helper returns a strong ETag for a movie. The version number changes on
+// every update, so it identifies the plain representation of the movie. Other
+// representations (picking out fields, embedding related records or translated) can
+// change without the version changing, so for those the body is passed in and a hash of
+// it is appended.
+func movieETag(movie *data.Movie, body []byte) string {
+	tag := strconv.FormatInt(int64(movie.Version), 10)
+
+	if body != nil {
+		sum := sha256.Sum256(body)
+		tag += "-" + hex.EncodeToString(sum[:8])
+	}
+
+	return `"` + tag + `"`
+}
+
+// The renderedMovieETag() helper returns the ETag for a movie rendered with view, adding
+// a hash of the rendered movie unless the view is plain.
+func (app *application) renderedMovieETag(movie *data.Movie, view movieView, rendered any) (string, error) {
+	if view.plain() {
+		return movieETag(movie, nil), nil
+	}
+
+	body, err := json.Marshal(rendered)
+	if err != nil {
+		return "", err
+	}
+
+	return movieETag(movie, body), nil
+}
+
+// The weakETag() helper returns a weak ETag for a response body. It is used for listings,
+// where the body depends on many records and is only compared for equivalence.
+func weakETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// The notModified() helper reports whether the request's If-None-Match header matches
+// etag, using the weak comparison from RFC 9110. If it does, it sends a 304 Not Modified
+// response and the caller should stop.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// The ifMatch() helper reports whether the request's If-Match header allows a change to
+// a movie which is currently at version, using the strong comparison from RFC 9110. Any
+// view hash in an ETag is ignored, because the version alone identifies the movie's
+// state. A missing header always matches.
+func (app *application) ifMatch(r *http.Request, version int32) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" {
+			return true
+		}
+		if len(tag) < 2 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+			continue
+		}
+
+		tag, _, _ = strings.Cut(tag[1:len(tag)-1], "-")
+
+		n, err := strconv.ParseInt(tag, 10, 32)
+		if err == nil && int32(n) == version {
+			return true
+		}
+	}
+
+	return false
+}
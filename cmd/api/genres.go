@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// GET /v1/genres
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// POST /v1/genres
+//
+// Adds a genre. The slug is worked out from the name if it isn't given, and aliases are
+// converted to slug form, so "Sci Fi" is stored as "sci-fi".
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Slug    string   `json:"slug"`
+		Name    string   `json:"name"`
+		Parent  string   `json:"parent"`
+		Aliases []string `json:"aliases"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badBadRequestResponse(w, r, err)
+		return
+	}
+
+	genre := &data.Genre{
+		Slug:    input.Slug,
+		Name:    input.Name,
+		Parent:  data.GenreSlug(input.Parent),
+		Aliases: genreAliases(input.Aliases),
+	}
+	if genre.Slug == "" {
+		genre.Slug = data.GenreSlug(input.Name)
+	}
+
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if _, ok := taxonomy.Canonical(genre.Slug); ok {
+		v.AddError("slug", "a genre or alias with this name already exists")
+	}
+
+	if data.ValidateGenre(v, genre, taxonomy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Insert(genre)
+	if err != nil {
+		app.genreWriteError(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%s", genre.Slug))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/genres/:slug
+func (app *application) showGenreHandler(w http.ResponseWriter, r *http.Request) {
+	genre, ok := app.readGenre(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// PATCH /v1/genres/:slug
+//
+// Changes a genre's name, parent or aliases. Aliases replace the existing list.
+func (app *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	genre, ok := app.readGenre(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name    *string  `json:"name"`
+		Parent  *string  `json:"parent"`
+		Aliases []string `json:"aliases"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badBadRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		genre.Name = *input.Name
+	}
+	if input.Parent != nil {
+		genre.Parent = data.GenreSlug(*input.Parent)
+	}
+	if input.Aliases != nil {
+		genre.Aliases = genreAliases(input.Aliases)
+	}
+
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre, taxonomy); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Update(genre)
+	if err != nil {
+		app.genreWriteError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/genres/:slug
+//
+// Removes a genre, which is only allowed once no movie uses it.
+func (app *application) deleteGenreHandler(w http.ResponseWriter, r *http.Request) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	err := app.models.Genres.Delete(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrGenreInUse):
+			app.errorResponse(w, r, http.StatusConflict, "the genre is still used by some movies")
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "genre successfully deleted"}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// The readGenre() helper looks up the genre named by the :slug parameter, which may also
+// be one of its aliases, sending a 404 Not Found response if there isn't one.
+func (app *application) readGenre(w http.ResponseWriter, r *http.Request) (*data.Genre, bool) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	slug, ok := taxonomy.Canonical(name)
+	if !ok {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	genre, err := app.models.Genres.Get(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return genre, true
+}
+
+// The genreWriteError() helper sends the response for an error saving a genre.
+func (app *application) genreWriteError(w http.ResponseWriter, r *http.Request, err error) {
+	v := validator.New()
+
+	switch {
+	case errors.Is(err, data.ErrDuplicateGenre):
+		v.AddError("slug", "a genre with this slug already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrDuplicateAlias):
+		v.AddError("aliases", "must not contain aliases of other genres")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// genreAliases converts aliases given by a client to slug form.
+func genreAliases(aliases []string) []string {
+	slugs := make([]string, len(aliases))
+	for i, alias := range aliases {
+		slugs[i] = data.GenreSlug(alias)
+	}
+	return slugs
+}
@@ -5,25 +5,44 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"greenlight.natenine.com/internal/data"
 	"greenlight.natenine.com/internal/validator"
 )
 
 type envelope map[string]any
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readInt64Param(r, "id")
+}
+
+// The readVersionParam() helper reads the :version URL parameter used by the movie
+// revision history endpoints. Versions are stored as 32-bit integers, so a larger one
+// is as invalid as a malformed one.
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	version, err := app.readInt64Param(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		return 0, errors.New("invalid version parameter")
+	}
+	return int32(version), nil
+}
+
+// The readInt64Param() helper reads a positive integer from the named URL parameter.
+func (app *application) readInt64Param(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
-	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+	n, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
-	return id, nil
+	return n, nil
 }
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
@@ -50,7 +69,14 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
-	dec := json.NewDecoder(r.Body)
+	return app.decodeJSON(r.Body, dst)
+}
+
+// The decodeJSON() helper decodes a single JSON value from body into dst, turning any
+// decoding errors into messages which can be sent to the client. It is used by readJSON()
+// and for documents, such as patched movies, which are built from the request body.
+func (app *application) decodeJSON(body io.Reader, dst any) error {
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 
 	// Decode the request body into the target destination
@@ -152,6 +178,69 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// The readTime() helper reads a timestamp from the query string, accepting either an
+// RFC 3339 timestamp or a plain YYYY-MM-DD date (taken as midnight UTC). If no matching
+// key could be found it returns the provided default value, and invalid values are
+// recorded in the Validator.
+func (app *application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, s)
+	}
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+		return defaultValue
+	}
+
+	return t
+}
+
+// The readBool() helper reads a boolean value from the query string, accepting the
+// values understood by strconv.ParseBool. If no matching key could be found it returns
+// the provided default value, and invalid values are recorded in the Validator.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// The setPageLinks() helper fills in the next and prev links in the metadata from its
+// cursors, keeping the rest of the request's query string so that the filters and
+// sort order carry over to the linked pages.
+func (app *application) setPageLinks(r *http.Request, metadata *data.Metadata) {
+	link := func(key, cursor string) string {
+		qs := r.URL.Query()
+		qs.Del("page")
+		qs.Del("after")
+		qs.Del("before")
+		qs.Set(key, cursor)
+		return r.URL.Path + "?" + qs.Encode()
+	}
+
+	if metadata.NextCursor != "" {
+		metadata.Next = link("after", metadata.NextCursor)
+	}
+	if metadata.PrevCursor != "" {
+		metadata.Prev = link("before", metadata.PrevCursor)
+	}
+}
+
 // The background() helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
 
@@ -170,3 +259,36 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// The periodic() helper runs fn straight away and then every interval, in a background
+// goroutine, until the server starts shutting down. Errors and panics are logged and
+// don't stop the next run.
+func (app *application) periodic(name string, interval time.Duration, fn func() error) {
+	run := func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), map[string]string{"job": name})
+			}
+		}()
+
+		err := fn()
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": name})
+		}
+	}
+
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			run()
+
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"greenlight.natenine.com/internal/data"
+)
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key header accepted.
+const maxIdempotencyKeyLength = 255
+
+// The idempotent() middleware lets clients safely retry a POST request by sending an
+// Idempotency-Key header. The first request with a key is processed as normal and its
+// response is stored; retries with the same key and body get the stored response back
+// instead of being processed again. Reusing a key with a different request, or retrying
+// while the first request is still being processed, gets a 409 Conflict response.
+//
+// Keys are scoped to the authenticated user (or shared by anonymous users) and the
+// endpoint, and are kept for the configured idempotency key TTL. Server errors aren't
+// stored, so that the request can be retried with the same key.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if !validIdempotencyKey(key) {
+			app.badBadRequestResponse(w, r, fmt.Errorf("Idempotency-Key header must be 1 to %d printable ASCII characters", maxIdempotencyKeyLength))
+			return
+		}
+
+		// The body is read up front to fingerprint the request, and then handed on to
+		// the handler as if it hadn't been touched.
+		maxBytes := 1_048_576
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				app.badBadRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+				return
+			}
+			app.serveErrorResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		claim := &data.IdempotencyKey{
+			UserID:      app.contextGetUser(r).ID,
+			Endpoint:    r.Method + " " + r.URL.Path,
+			Key:         key,
+			Fingerprint: requestFingerprint(r, body),
+		}
+
+		stored, err := app.models.IdempotencyKeys.Claim(claim, app.config.idempotency.ttl)
+		if err != nil {
+			app.serveErrorResponse(w, r, err)
+			return
+		}
+
+		if stored != nil {
+			switch {
+			case !bytes.Equal(stored.Fingerprint, claim.Fingerprint):
+				app.idempotencyKeyReusedResponse(w, r)
+			case !stored.Completed:
+				app.idempotencyKeyInUseResponse(w, r)
+			default:
+				for name, values := range stored.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+			}
+			return
+		}
+
+		// If the handler panics or fails, free the key so that the client can retry.
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			err := app.models.IdempotencyKeys.Release(claim)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		// Only the headers set by the handler are stored. Those set by the middleware
+		// before it, such as the CORS headers, depend on the request and are set again
+		// when the response is replayed.
+		before := w.Header().Clone()
+
+		rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		if rec.status >= 500 {
+			return
+		}
+
+		header := make(http.Header)
+		for name, values := range w.Header() {
+			if !slices.Equal(values, before[name]) {
+				header[name] = values
+			}
+		}
+
+		err = app.models.IdempotencyKeys.Complete(claim, rec.status, header, rec.body.Bytes())
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		completed = true
+	}
+}
+
+// validIdempotencyKey reports whether key is short enough and only contains printable
+// ASCII characters.
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestFingerprint returns a hash of the parts of the request which affect its
+// response: the method, the URL including the query string, and the body.
+func requestFingerprint(r *http.Request, body []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.RequestURI()))
+	h.Write([]byte{0})
+	h.Write(body)
+
+	return h.Sum(nil)
+}
+
+// idempotencyRecorder passes a response through to the client while keeping a copy of
+// its status and body, so that it can be stored against an idempotency key.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// The readLanguages() helper returns the languages the client would like responses in,
+// most preferred first, as canonical BCP 47 tags. A comma-separated lang query parameter
+// takes priority over the Accept-Language header. Invalid tags in the lang parameter are
+// recorded in the Validator, while ones in the header are ignored.
+func (app *application) readLanguages(r *http.Request, v *validator.Validator) []string {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		var languages []string
+
+		for _, tag := range strings.Split(lang, ",") {
+			canonical, ok := data.CanonicalLanguageTag(strings.TrimSpace(tag))
+			if !ok {
+				v.AddError("lang", "must contain valid BCP 47 language tags")
+				continue
+			}
+			languages = append(languages, canonical)
+		}
+
+		return languages
+	}
+
+	return parseAcceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// parseAcceptLanguage returns the language tags from an Accept-Language header, ordered
+// by their quality values. Tags with a quality of 0, and the "*" wildcard, are left out.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var languages []weighted
+
+	for _, item := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		canonical, ok := data.CanonicalLanguageTag(strings.TrimSpace(tag))
+		if !ok || q <= 0 {
+			continue
+		}
+
+		languages = append(languages, weighted{tag: canonical, q: q})
+	}
+
+	// A stable sort keeps tags with the same quality in the order the client sent them.
+	slices.SortStableFunc(languages, func(a, b weighted) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	tags := make([]string, len(languages))
+	for i, language := range languages {
+		tags[i] = language.tag
+	}
+	return tags
+}
+
+// matchTranslation picks the translation which best suits the client's languages. For
+// each language in order of preference, an exact match is best, then a translation in
+// the same base language ("pt-BR" for "pt", or "pt-PT" for "pt-BR"). It returns nil if
+// nothing matches.
+func matchTranslation(languages []string, translations []*data.MovieTranslation) *data.MovieTranslation {
+	for _, language := range languages {
+		for _, tr := range translations {
+			if tr.Language == language {
+				return tr
+			}
+		}
+
+		for _, tr := range translations {
+			if data.BaseLanguage(tr.Language) == data.BaseLanguage(language) {
+				return tr
+			}
+		}
+	}
+
+	return nil
+}
+
+// The localizeMovies() helper replaces the title of each movie with the translation which
+// best suits the client's languages, and fills in the synopsis. The translations used are
+// returned, keyed by movie id.
+func (app *application) localizeMovies(movies []*data.Movie, languages []string) (map[int64]*data.MovieTranslation, error) {
+	chosen := make(map[int64]*data.MovieTranslation)
+
+	if len(movies) == 0 || len(languages) == 0 {
+		return chosen, nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	translations, err := app.models.Translations.GetForMovies(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, movie := range movies {
+		tr := matchTranslation(languages, translations[movie.ID])
+		if tr == nil {
+			continue
+		}
+
+		movie.OriginalTitle = movie.Title
+		movie.Title = tr.Title
+		movie.Synopsis = tr.Synopsis
+		movie.Language = tr.Language
+
+		chosen[movie.ID] = tr
+	}
+
+	return chosen, nil
+}
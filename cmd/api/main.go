@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -15,6 +17,7 @@ import (
 	"greenlight.natenine.com/internal/data"
 	"greenlight.natenine.com/internal/jsonlog"
 	"greenlight.natenine.com/internal/mailer"
+	"greenlight.natenine.com/internal/storage"
 	"greenlight.natenine.com/internal/vcs"
 
 	_ "github.com/lib/pq"
@@ -32,9 +35,11 @@ type config struct {
 		maxIdleTime  string
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps          float64
+		burst        int
+		enabled      bool
+		suggestRPS   float64
+		suggestBurst int
 	}
 	smtp struct {
 		host     string
@@ -46,14 +51,61 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	similar struct {
+		weights  data.SimilarityWeights
+		cacheTTL time.Duration
+	}
+	recommendations struct {
+		interval time.Duration
+	}
+	stats struct {
+		cacheTTL time.Duration
+	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	events struct {
+		heartbeat time.Duration
+		retention time.Duration
+	}
+	webhooks struct {
+		workers      int
+		timeout      time.Duration
+		pollInterval time.Duration
+		maxAttempts  int
+		retention    time.Duration
+		allowPrivate bool
+	}
+	popularity struct {
+		sampleRate    float64
+		flushInterval time.Duration
+		bufferSize    int
+		halfLife      time.Duration
+		interval      time.Duration
+	}
+	storage struct {
+		dir    string
+		secret string
+		urlTTL time.Duration
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+	signer  storage.Signer
+	views   *viewBuffer
+	wg      sync.WaitGroup
+
+	// movieEvents passes movie changes on to the open event streams.
+	movieEvents *movieEventHub
+
+	// shutdown is closed when the server starts shutting down, to stop long-running
+	// background goroutines.
+	shutdown chan struct{}
 }
 
 func main() {
@@ -74,6 +126,8 @@ func main() {
 	flag.Float64Var(&cfg.limiter.rps, "lmiter-rps", 2, "Rate limit maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Float64Var(&cfg.limiter.suggestRPS, "limiter-suggest-rps", 10, "Rate limit maximum requests per second for title suggestions")
+	flag.IntVar(&cfg.limiter.suggestBurst, "limiter-suggest-burst", 20, "Rate limiter maximum burst for title suggestions")
 
 	// configuring the SMTP server setting
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
@@ -88,6 +142,42 @@ func main() {
 		return nil
 	})
 
+	// Configuring storage for uploaded images
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory for uploaded files")
+	flag.StringVar(&cfg.storage.secret, "storage-url-secret", os.Getenv("GREENLIGHT_STORAGE_URL_SECRET"), "Secret used to sign links to uploaded files")
+	flag.DurationVar(&cfg.storage.urlTTL, "storage-url-ttl", time.Hour, "How long signed links to uploaded files are valid for")
+
+	// Configuring similar movie scoring
+	flag.Float64Var(&cfg.similar.weights.Genres, "similar-weight-genres", 0.5, "Weight of genre overlap in similar movie scores")
+	flag.Float64Var(&cfg.similar.weights.Year, "similar-weight-year", 0.15, "Weight of release year proximity in similar movie scores")
+	flag.Float64Var(&cfg.similar.weights.Title, "similar-weight-title", 0.15, "Weight of title similarity in similar movie scores")
+	flag.Float64Var(&cfg.similar.weights.CoRating, "similar-weight-co-rating", 0.2, "Weight of users liking both movies in similar movie scores")
+	flag.DurationVar(&cfg.similar.cacheTTL, "similar-cache-ttl", 10*time.Minute, "How long similar movies are cached for (0 to disable)")
+
+	flag.DurationVar(&cfg.recommendations.interval, "recommendations-interval", time.Hour, "How often recommendations are recalculated (0 to disable)")
+
+	// Configuring view tracking and popularity scores
+	flag.Float64Var(&cfg.popularity.sampleRate, "views-sample-rate", 0.25, "Fraction of movie views which are recorded (0-1)")
+	flag.DurationVar(&cfg.popularity.flushInterval, "views-flush-interval", 30*time.Second, "How often buffered movie views are written to the database")
+	flag.IntVar(&cfg.popularity.bufferSize, "views-buffer-size", 10000, "Number of different movies whose views are buffered before flushing early")
+	flag.DurationVar(&cfg.popularity.halfLife, "popularity-half-life", 72*time.Hour, "How long it takes a view to count half as much towards popularity")
+	flag.DurationVar(&cfg.popularity.interval, "popularity-interval", 15*time.Minute, "How often popularity scores are recalculated (0 to disable)")
+
+	flag.DurationVar(&cfg.stats.cacheTTL, "stats-cache-ttl", 5*time.Minute, "How long catalogue statistics are cached for (0 to disable)")
+
+	flag.DurationVar(&cfg.events.heartbeat, "events-heartbeat-interval", 15*time.Second, "How often a heartbeat is sent on movie event streams")
+	flag.DurationVar(&cfg.events.retention, "events-retention", 7*24*time.Hour, "How long movie events are kept for streams to resume from")
+
+	// Configuring webhook deliveries
+	flag.IntVar(&cfg.webhooks.workers, "webhooks-workers", 4, "Number of webhook deliveries sent at once")
+	flag.DurationVar(&cfg.webhooks.timeout, "webhooks-timeout", 10*time.Second, "How long to wait for a webhook receiver to respond")
+	flag.DurationVar(&cfg.webhooks.pollInterval, "webhooks-poll-interval", 5*time.Second, "How often the queue is checked for webhook deliveries which are due")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhooks-max-attempts", 10, "Number of attempts at a webhook delivery before it fails")
+	flag.DurationVar(&cfg.webhooks.retention, "webhooks-retention", 30*24*time.Hour, "How long completed webhook deliveries are kept for")
+	flag.BoolVar(&cfg.webhooks.allowPrivate, "webhooks-allow-private-addresses", false, "Allow webhooks to be sent to loopback and private network addresses (for development)")
+
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-key-ttl", 24*time.Hour, "How long responses to requests with an Idempotency-Key are kept for replaying")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -99,6 +189,33 @@ func main() {
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	weights := cfg.similar.weights
+	if weights.Genres < 0 || weights.Year < 0 || weights.Title < 0 || weights.CoRating < 0 || weights.Genres+weights.Year+weights.Title+weights.CoRating == 0 {
+		logger.PrintFatal(errors.New("similar movie weights must not be negative and at least one must be positive"), nil)
+	}
+
+	if cfg.popularity.sampleRate <= 0 || cfg.popularity.sampleRate > 1 {
+		logger.PrintFatal(errors.New("views sample rate must be greater than 0 and at most 1"), nil)
+	}
+	if cfg.popularity.flushInterval <= 0 || cfg.popularity.bufferSize < 1 || cfg.popularity.halfLife <= 0 {
+		logger.PrintFatal(errors.New("views flush interval, buffer size and popularity half-life must be positive"), nil)
+	}
+
+	if cfg.events.heartbeat <= 0 || cfg.events.retention <= 0 {
+		logger.PrintFatal(errors.New("events heartbeat interval and retention must be positive"), nil)
+	}
+
+	if cfg.webhooks.workers < 1 || cfg.webhooks.maxAttempts < 1 {
+		logger.PrintFatal(errors.New("webhooks workers and max attempts must be at least 1"), nil)
+	}
+	if cfg.webhooks.timeout <= 0 || cfg.webhooks.pollInterval <= 0 || cfg.webhooks.retention <= 0 {
+		logger.PrintFatal(errors.New("webhooks timeout, poll interval and retention must be positive"), nil)
+	}
+
+	if cfg.idempotency.ttl < data.IdempotencyLockTimeout {
+		logger.PrintFatal(fmt.Errorf("idempotency key TTL must be at least %s", data.IdempotencyLockTimeout), nil)
+	}
+
 	db, err := openDB(cfg)
 
 	if err != nil {
@@ -125,11 +242,38 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	store, err := storage.NewLocal(cfg.storage.dir)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// Without a configured secret, signed links only work until the server restarts.
+	secret := []byte(cfg.storage.secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+
+		_, err = rand.Read(secret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("no storage URL secret set, using a random one", nil)
+	}
+
 	app := application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		models: data.NewModels(db, data.CacheTTLs{
+			Similar: cfg.similar.cacheTTL,
+			Stats:   cfg.stats.cacheTTL,
+		}),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+		signer:  storage.NewSigner(secret),
+		views:   newViewBuffer(cfg.popularity.bufferSize),
+
+		movieEvents: newMovieEventHub(),
+
+		shutdown: make(chan struct{}),
 	}
 
 	err = app.serve()
@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/julienschmidt/httprouter"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 	"greenlight.natenine.com/internal/data"
@@ -22,6 +23,12 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		// as Go unwinds the stack).
 		defer func() {
 			if err := recover(); err != nil {
+				// http.ErrAbortHandler is used to abort a response which has already
+				// been partly written, so pass it on to the server untouched.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
 				w.Header().Set("Connection", "close")
 
 				app.serveErrorResponse(w, r, fmt.Errorf("%s", err))
@@ -31,17 +38,28 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimiter(next http.Handler) http.Handler {
+// clientLimiter holds a token bucket rate limiter for each client IP address.
+type clientLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*client
+	rps     float64
+	burst   int
+}
 
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
 
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
+// newClientLimiter returns a clientLimiter allowing each client rps requests per second,
+// with bursts of up to burst requests. A background goroutine removes clients which
+// haven't been seen for three minutes.
+func newClientLimiter(rps float64, burst int) *clientLimiter {
+	cl := &clientLimiter{
+		clients: make(map[string]*client),
+		rps:     rps,
+		burst:   burst,
+	}
 
 	go func() {
 		for {
@@ -49,40 +67,61 @@ func (app *application) rateLimiter(next http.Handler) http.Handler {
 
 			// Lock the mutex to prevent any rate limiter checks from happening while
 			// the cleanup is taking place.
-			mu.Lock()
+			cl.mu.Lock()
 
-			for ip, client := range clients {
+			for ip, client := range cl.clients {
 				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
+					delete(cl.clients, ip)
 				}
 			}
-			mu.Unlock()
+			cl.mu.Unlock()
 		}
 	}()
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Only carry out the check if rate limiting is enabled.
-		if app.config.limiter.enabled {
-			ip := realip.FromRequest(r)
 
-			mu.Lock()
+	return cl
+}
 
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					// This value is set from the config struct
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
-			}
+// allow reports whether the client making the request is within its budget.
+func (cl *clientLimiter) allow(r *http.Request) bool {
+	ip := realip.FromRequest(r)
 
-			// Update the last seen time for the client
-			clients[ip].lastSeen = time.Now()
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
+	if _, found := cl.clients[ip]; !found {
+		cl.clients[ip] = &client{
+			limiter: rate.NewLimiter(rate.Limit(cl.rps), cl.burst),
+		}
+	}
+
+	// Update the last seen time for the client
+	cl.clients[ip].lastSeen = time.Now()
+
+	return cl.clients[ip].limiter.Allow()
+}
 
-			mu.Unlock()
+// The rateLimiter() middleware applies a rate limiting budget to the requests passing
+// through it. It wraps the whole router with the global budget, and routes with a budget
+// of their own are served by budgetedRoutes() before reaching it.
+func (app *application) rateLimiter(limiter *clientLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.limiter.enabled && !limiter.allow(r) {
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The budgetedRoutes() middleware serves requests which match one of routes, the routes
+// with rate limiting budgets of their own, and passes everything else on to next. It
+// sits in front of the global rateLimiter, so those requests aren't counted against the
+// global budget as well.
+func (app *application) budgetedRoutes(routes *httprouter.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handle, params, _ := routes.Lookup(r.Method, r.URL.Path); handle != nil {
+			handle(w, r, params)
+			return
 		}
 		next.ServeHTTP(w, r)
 	})
@@ -202,7 +241,7 @@ func (app *application) enableCors(next http.Handler) http.Handler {
 
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match, Last-Event-ID, Runtime-Format")
 
 						w.WriteHeader(http.StatusOK)
 						return
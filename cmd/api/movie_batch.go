@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+const maxBatchOperations = 100
+
+// batchOperation is a single create, update or delete in a POST /v1/movies/batch
+// request. The movie fields use pointers so that updates can be partial, in the same way
+// as PATCH /v1/movies/:id.
+type batchOperation struct {
+	Op      string        `json:"op"`
+	ID      int64         `json:"id"`
+	Version *int32        `json:"version"`
+	Title   *string       `json:"title"`
+	Year    *int32        `json:"year"`
+	Runtime *data.Runtime `json:"runtime"`
+	Genres  []string      `json:"genres"`
+}
+
+// batchResult reports the outcome of a single operation, using the status code which
+// the equivalent single-movie endpoint would have returned.
+type batchResult struct {
+	Op     string      `json:"op"`
+	Status int         `json:"status"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  any         `json:"error,omitempty"`
+}
+
+// POST /v1/movies/batch
+//
+// Runs a list of operations in a single transaction. When atomic is true (the default)
+// the first failing operation rolls back the whole batch, and the remaining operations
+// are reported with a 424 Failed Dependency status. Otherwise each operation succeeds or
+// fails on its own. Updates must give the version they expect to be editing, and deletes
+// may do so.
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Atomic     *bool            `json:"atomic"`
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badBadRequestResponse(w, r, err)
+		return
+	}
+
+	atomic := input.Atomic == nil || *input.Atomic
+
+	v := validator.New()
+
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", maxBatchOperations))
+
+	for i, op := range input.Operations {
+		key := fmt.Sprintf("operations[%d]", i)
+
+		v.Check(validator.PermittedValue(op.Op, "create", "update", "delete"), key+".op", "must be create, update or delete")
+
+		switch op.Op {
+		case "update":
+			v.Check(op.ID > 0, key+".id", "must be provided")
+			v.Check(op.Version != nil, key+".version", "must be provided")
+		case "delete":
+			v.Check(op.ID > 0, key+".id", "must be provided")
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	batch, err := app.models.Movies.NewBatch(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+	defer batch.Rollback()
+
+	results := make([]batchResult, len(input.Operations))
+	failed := false
+
+	for i, op := range input.Operations {
+		results[i].Op = op.Op
+
+		if failed && atomic {
+			results[i].Status = http.StatusFailedDependency
+			results[i].Error = "not attempted because an earlier operation failed"
+			continue
+		}
+
+		var opErr error
+
+		if atomic {
+			opErr = app.runBatchOperation(batch, taxonomy, op, &results[i])
+		} else {
+			err = batch.Do(func() error {
+				opErr = app.runBatchOperation(batch, taxonomy, op, &results[i])
+				return opErr
+			})
+			if err != nil {
+				app.serveErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		if opErr != nil {
+			status, message, ok := batchErrorStatus(opErr)
+			if !ok {
+				app.serveErrorResponse(w, r, opErr)
+				return
+			}
+
+			results[i].Status = status
+			results[i].Error = message
+			results[i].Movie = nil
+			failed = true
+		}
+	}
+
+	committed := !(failed && atomic)
+
+	if committed {
+		err = batch.Commit()
+		if err != nil {
+			app.serveErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		// Operations which succeeded before the failure have been rolled back too.
+		for i := range results {
+			if results[i].Error == nil {
+				results[i].Status = http.StatusFailedDependency
+				results[i].Error = "rolled back because another operation failed"
+				results[i].Movie = nil
+			}
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"committed": committed, "results": results}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// runBatchOperation applies a single operation to the batch and fills in the successful
+// result. Failures are returned as errors for the caller to map onto a status code.
+func (app *application) runBatchOperation(batch *data.MovieBatch, taxonomy *data.GenreTaxonomy, op batchOperation, result *batchResult) error {
+	switch op.Op {
+	case "create":
+		movie := &data.Movie{Genres: op.Genres}
+		applyBatchFields(movie, op)
+
+		err := validateBatchMovie(movie, taxonomy)
+		if err != nil {
+			return err
+		}
+
+		err = batch.Insert(movie)
+		if err != nil {
+			return err
+		}
+
+		result.Status = http.StatusCreated
+		result.Movie = movie
+
+	case "update":
+		movie, err := batch.Get(op.ID)
+		if err != nil {
+			return err
+		}
+
+		// The version in the request is the version the client expects to be editing,
+		// so checking it against the database is left to the edit conflict check in
+		// Update.
+		movie.Version = *op.Version
+		applyBatchFields(movie, op)
+		if op.Genres != nil {
+			movie.Genres = op.Genres
+		}
+
+		err = validateBatchMovie(movie, taxonomy)
+		if err != nil {
+			return err
+		}
+
+		err = batch.Update(movie)
+		if err != nil {
+			return err
+		}
+
+		result.Status = http.StatusOK
+		result.Movie = movie
+
+	case "delete":
+		var version int32
+		if op.Version != nil {
+			version = *op.Version
+		}
+
+		err := batch.Delete(op.ID, version)
+		if err != nil {
+			return err
+		}
+
+		result.Status = http.StatusOK
+	}
+
+	return nil
+}
+
+func applyBatchFields(movie *data.Movie, op batchOperation) {
+	if op.Title != nil {
+		movie.Title = *op.Title
+	}
+	if op.Year != nil {
+		movie.Year = *op.Year
+	}
+	if op.Runtime != nil {
+		movie.Runtime = *op.Runtime
+	}
+}
+
+// batchValidationError carries the validation errors for a single batch operation.
+type batchValidationError struct {
+	errors map[string]string
+}
+
+func (e batchValidationError) Error() string {
+	return "movie failed validation"
+}
+
+func validateBatchMovie(movie *data.Movie, taxonomy *data.GenreTaxonomy) error {
+	v := validator.New()
+	if data.ValidateMovie(v, movie, taxonomy); !v.Valid() {
+		return batchValidationError{errors: v.Errors}
+	}
+	return nil
+}
+
+// batchErrorStatus maps an operation error onto the status code and message the
+// single-movie endpoints use for it. It returns false for unexpected errors.
+func batchErrorStatus(err error) (int, any, bool) {
+	var validationErr batchValidationError
+
+	switch {
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity, validationErr.errors, true
+	case errors.Is(err, data.ErrRecordNotFound):
+		return http.StatusNotFound, "the requested resource could not be found", true
+	case errors.Is(err, data.ErrEditConflict):
+		return http.StatusConflict, "unable to update the record due to an edit conflict, please try again", true
+	default:
+		return 0, nil, false
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// movieEventPageSize is the number of events read from the log at a time when catching
+// up.
+const movieEventPageSize = 500
+
+// movieEventHub fans the movie events read by the listener out to every open event
+// stream. Each stream has a buffered channel; a stream which falls so far behind that
+// its buffer fills is closed, and the client can resume from the last event it got.
+type movieEventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan *data.MovieEvent]struct{}
+	closed      bool
+}
+
+func newMovieEventHub() *movieEventHub {
+	return &movieEventHub{subscribers: make(map[chan *data.MovieEvent]struct{})}
+}
+
+// subscribe returns a channel which receives each event published from now on, and a
+// function to stop receiving them. The channel is closed when the hub shuts down.
+func (h *movieEventHub) subscribe() (<-chan *data.MovieEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan *data.MovieEvent, 256)
+
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	h.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *movieEventHub) publish(events []*data.MovieEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		for _, event := range events {
+			select {
+			case ch <- event:
+				continue
+			default:
+			}
+
+			delete(h.subscribers, ch)
+			close(ch)
+			break
+		}
+	}
+}
+
+// close ends every stream, and any opened afterwards. It is called when the server
+// starts shutting down, as open streams would otherwise keep it waiting.
+func (h *movieEventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+	h.closed = true
+}
+
+// The startMovieEventListener() helper listens for notifications of new movie events
+// and publishes them to the hub, until the server shuts down. Notifications only say
+// that there is something new: the new events are given their positions in the log and
+// then read from it, which also picks up anything missed while the listener was
+// reconnecting.
+func (app *application) startMovieEventListener() error {
+	listener := pq.NewListener(app.config.db.dsn, time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"listener": data.MovieEventsChannel})
+		}
+	})
+
+	err := listener.Listen(data.MovieEventsChannel)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	_, lastID, err := app.models.MovieEvents.Bounds()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	catchUp := func() {
+		err := app.models.MovieEvents.Position()
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"listener": data.MovieEventsChannel})
+			return
+		}
+
+		for {
+			events, err := app.models.MovieEvents.GetAfter(lastID, movieEventPageSize)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"listener": data.MovieEventsChannel})
+				return
+			}
+			if len(events) == 0 {
+				return
+			}
+
+			app.movieEvents.publish(events)
+			lastID = events[len(events)-1].ID
+
+			if len(events) < movieEventPageSize {
+				return
+			}
+		}
+	}
+
+	app.background(func() {
+		defer listener.Close()
+
+		// Check the connection now and then, catching up in case a notification was
+		// lost.
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-listener.Notify:
+				catchUp()
+			case <-ticker.C:
+				go listener.Ping()
+				catchUp()
+			}
+		}
+	})
+
+	return nil
+}
+
+// GET /v1/movies/events streams movie changes as Server-Sent Events. Each event has its
+// position in the log as its id, the kind of change as its type, and the event as JSON
+// data. Clients resume by sending the last id they got in a Last-Event-ID header (or the
+// last_event_id query parameter); without one, only changes from now on are sent. If events after the
+// given id have expired from the log, a reset event is sent first so that the client
+// knows to discard anything it has cached.
+//
+// A comment is sent every heartbeat interval to keep the connection open, and the
+// client's token and permissions are checked again each time. If they are no longer
+// valid a revoked event is sent and the stream ends.
+func (app *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	lastID := int64(-1)
+
+	if s := app.readString(r.URL.Query(), "last_event_id", r.Header.Get("Last-Event-ID")); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		v.Check(err == nil && id >= 0, "last_event_id", "must be a non-negative integer")
+		lastID = id
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Subscribe before reading the backlog, so that nothing is missed in between. Events
+	// which turn up in both are only sent once.
+	events, unsubscribe := app.movieEvents.subscribe()
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+
+	// The server's write timeout would otherwise cut the stream off.
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(s string) bool {
+		_, err := fmt.Fprint(w, s)
+		if err == nil {
+			err = rc.Flush()
+		}
+		return err == nil
+	}
+
+	sendEvent := func(event *data.MovieEvent) bool {
+		js, err := json.Marshal(event)
+		if err != nil {
+			app.logError(r, err)
+			return false
+		}
+		lastID = event.ID
+		return send(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Kind, js))
+	}
+
+	if !send(fmt.Sprintf("retry: %d\n\n", (5 * time.Second).Milliseconds())) {
+		return
+	}
+
+	if lastID >= 0 {
+		oldest, _, err := app.models.MovieEvents.Bounds()
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		if oldest > lastID+1 {
+			if !send(fmt.Sprintf("id: %d\nevent: reset\ndata: {}\n\n", oldest-1)) {
+				return
+			}
+			lastID = oldest - 1
+		}
+
+		for {
+			backlog, err := app.models.MovieEvents.GetAfter(lastID, movieEventPageSize)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+
+			for _, event := range backlog {
+				if !sendEvent(event) {
+					return
+				}
+			}
+
+			if len(backlog) < movieEventPageSize {
+				break
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(app.config.events.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.ID <= lastID {
+				continue
+			}
+			if !sendEvent(event) {
+				return
+			}
+
+		case <-heartbeat.C:
+			permitted, err := app.stillPermitted(r, "movies:read")
+			if err != nil {
+				app.logError(r, err)
+			} else if !permitted {
+				send("event: revoked\ndata: {}\n\n")
+				return
+			}
+
+			if !send(": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// The stillPermitted() helper checks that the authentication token a long-lived request
+// was made with is still valid, and that its user is still activated and has the
+// permission code.
+func (app *application) stillPermitted(r *http.Request, code string) (bool, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if !user.Activated {
+		return false, nil
+	}
+
+	permissions, err := app.models.Permissions.GetForAllUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include(code), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// After this many rows the buffered output is flushed to the client and the write
+// deadline is pushed back, so that long exports aren't cut off by the server's
+// WriteTimeout.
+const exportFlushRows = 1000
+
+// movieEncoder writes movies to an export in a particular format. begin is called
+// before the first movie and end after the last one.
+type movieEncoder struct {
+	contentType string
+	begin       func() error
+	encode      func(*data.Movie) error
+	end         func() error
+}
+
+// GET /v1/movies/export?format=csv|ndjson|json
+//
+// Accepts the same filters as GET /v1/movies, but streams every matching movie rather
+// than a page of them.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieFilters
+		Format string
+	}
+
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.MovieFilters = app.readMovieFilters(qs, v)
+	input.Format = app.readString(qs, "format", "ndjson")
+
+	data.ValidateMovieFilters(v, &input.MovieFilters, taxonomy)
+
+	if v.Check(validator.PermittedValue(input.Format, "csv", "ndjson", "json"), "format", "must be csv, ndjson or json"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sw := &streamWriter{w: w}
+	bw := bufio.NewWriter(sw)
+	enc := newMovieEncoder(bw, input.Format)
+
+	w.Header().Set("Content-Type", enc.contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="movies.`+input.Format+`"`)
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+
+	n := 0
+
+	err = enc.begin()
+	if err == nil {
+		err = app.models.Movies.Export(r.Context(), input.MovieFilters, func(movie *data.Movie) error {
+			err := enc.encode(movie)
+			if err != nil {
+				return err
+			}
+
+			n++
+			if n%exportFlushRows == 0 {
+				err = bw.Flush()
+				if err != nil {
+					return err
+				}
+				rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+				return rc.Flush()
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = enc.end()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	if err != nil {
+		// If nothing has reached the client yet we can still send a normal error
+		// response. Otherwise the connection is aborted, so that the client sees an
+		// incomplete body rather than one which looks complete but has been truncated.
+		if !sw.started {
+			w.Header().Del("Content-Disposition")
+			app.serveErrorResponse(w, r, err)
+			return
+		}
+
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// streamWriter records whether any part of a streamed response has been written to the
+// client yet.
+type streamWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (sw *streamWriter) Write(b []byte) (int, error) {
+	sw.started = true
+	return sw.w.Write(b)
+}
+
+func newMovieEncoder(w *bufio.Writer, format string) movieEncoder {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+
+		return movieEncoder{
+			contentType: "text/csv",
+			begin: func() error {
+				return cw.Write([]string{"id", "created_at", "title", "year", "runtime", "genres", "version"})
+			},
+			encode: func(movie *data.Movie) error {
+				return cw.Write([]string{
+					strconv.FormatInt(movie.ID, 10),
+					movie.CreatedAt.Format(time.RFC3339),
+					movie.Title,
+					strconv.Itoa(int(movie.Year)),
+					strconv.Itoa(int(movie.Runtime)),
+					strings.Join(movie.Genres, ","),
+					strconv.Itoa(int(movie.Version)),
+				})
+			},
+			end: func() error {
+				cw.Flush()
+				return cw.Error()
+			},
+		}
+
+	case "json":
+		first := true
+
+		return movieEncoder{
+			contentType: "application/json",
+			begin: func() error {
+				_, err := w.WriteString(`{"movies":[`)
+				return err
+			},
+			encode: func(movie *data.Movie) error {
+				if !first {
+					w.WriteByte(',')
+				}
+				first = false
+
+				js, err := json.Marshal(movie)
+				if err != nil {
+					return err
+				}
+				_, err = w.Write(js)
+				return err
+			},
+			end: func() error {
+				_, err := w.WriteString("]}\n")
+				return err
+			},
+		}
+
+	default:
+		enc := json.NewEncoder(w)
+
+		return movieEncoder{
+			contentType: "application/x-ndjson",
+			begin:       func() error { return nil },
+			encode:      func(movie *data.Movie) error { return enc.Encode(movie) },
+			end:         func() error { return nil },
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/storage"
+	"greenlight.natenine.com/internal/validator"
+)
+
+const (
+	maxImageBytes  = 10 << 20
+	maxImagePixels = 40_000_000
+	thumbnailWidth = 320
+)
+
+// imageExtensions are the image types which can be uploaded, keyed by their sniffed
+// content type, along with the extension they're stored with.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// POST /v1/movies/:id/images
+//
+// Uploads a poster or backdrop as multipart/form-data, with the file in the "image" field
+// and "poster" or "backdrop" in the "kind" field. The type is sniffed from the content
+// rather than trusted from the request, and the image is decoded to check its dimensions
+// and produce a thumbnail.
+func (app *application) uploadMovieImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Allow some room on top of the image itself for the rest of the multipart body.
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageBytes+1<<20)
+
+	err = r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badBadRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+		case errors.Is(err, http.ErrNotMultipart):
+			app.badBadRequestResponse(w, r, errors.New("body must be multipart/form-data"))
+		default:
+			app.badBadRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	img := &data.MovieImage{
+		MovieID: id,
+		Kind:    r.FormValue("kind"),
+	}
+	if img.Kind == "" {
+		img.Kind = data.ImageKindPoster
+	}
+
+	v := validator.New()
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			v.AddError("image", "must be provided")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		app.badBadRequestResponse(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	img.Size = header.Size
+	if v.Check(img.Size <= maxImageBytes, "image", fmt.Sprintf("must not be larger than %d bytes", maxImageBytes)); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	img.ContentType, err = sniffContentType(file)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	if _, ok := imageExtensions[img.ContentType]; !ok {
+		v.AddError("image", "must be a JPEG, PNG or GIF image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Read just the header first, so that the dimensions can be checked before the whole
+	// image is decoded into memory.
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		v.AddError("image", "could not be decoded")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	img.Width, img.Height = config.Width, config.Height
+
+	v.Check(img.Width*img.Height <= maxImagePixels, "image", "has too many pixels")
+
+	if data.ValidateMovieImage(v, img); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	thumb, err := makeThumbnail(file, img.ContentType)
+	if err != nil {
+		v.AddError("image", "could not be decoded")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	name, err := randomName()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	base := fmt.Sprintf("movies/%d/%s", id, name)
+	img.Key = base + imageExtensions[img.ContentType]
+	img.ThumbnailKey = base + "_thumb" + thumb.extension
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.storage.Put(img.Key, file)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.storage.Put(img.ThumbnailKey, bytes.NewReader(thumb.body))
+	if err != nil {
+		app.deleteImageFiles(r, img)
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MovieImages.Insert(img)
+	if err != nil {
+		app.deleteImageFiles(r, img)
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	app.signImage(img)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/images/%d", id, img.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": img}, headers)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/movies/:id/images
+func (app *application) listMovieImagesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	images, err := app.models.MovieImages.GetForMovies([]int64{id})
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	movieImages := images[id]
+	if movieImages == nil {
+		movieImages = []*data.MovieImage{}
+	}
+
+	for _, img := range movieImages {
+		app.signImage(img)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": movieImages}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/movies/:id/images/:image_id
+func (app *application) showMovieImageHandler(w http.ResponseWriter, r *http.Request) {
+	img, ok := app.readMovieImage(w, r)
+	if !ok {
+		return
+	}
+
+	app.signImage(img)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"image": img}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/movies/:id/images/:image_id
+func (app *application) deleteMovieImageHandler(w http.ResponseWriter, r *http.Request) {
+	img, ok := app.readMovieImage(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.MovieImages.Delete(img.MovieID, img.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.deleteImageFiles(r, img)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/images/*key
+//
+// Serves an uploaded file. No authentication is needed, because the link itself carries
+// a signature and an expiry time; see signImage(). Stored files never change, so they
+// can be cached for as long as the link is valid.
+func (app *application) serveImageHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(httprouter.ParamsFromContext(r.Context()).ByName("key"), "/")
+
+	qs := r.URL.Query()
+
+	unix, err := strconv.ParseInt(qs.Get("expires"), 10, 64)
+	expires := time.Unix(unix, 0)
+
+	if err != nil || !app.signer.Verify(key, expires, qs.Get("signature")) {
+		app.invalidSignatureResponse(w, r)
+		return
+	}
+
+	file, err := app.storage.Open(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d, immutable", int(time.Until(expires).Seconds())))
+	w.Header().Set("ETag", strconv.Quote(key))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, path.Base(key), info.ModTime(), file)
+}
+
+// The readMovieImage() helper looks up the image named by the :id and :image_id
+// parameters, sending a 404 Not Found response if it doesn't exist.
+func (app *application) readMovieImage(w http.ResponseWriter, r *http.Request) (*data.MovieImage, bool) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	imageID, err := app.readInt64Param(r, "image_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	img, err := app.models.MovieImages.Get(movieID, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return img, true
+}
+
+// The signImage() helper fills in signed links to an image and its thumbnail. The expiry
+// time is rounded up to the next quarter of an hour, so that repeated requests get the
+// same links and clients can reuse cached copies.
+func (app *application) signImage(img *data.MovieImage) {
+	expires := time.Now().Add(app.config.storage.urlTTL).Truncate(15 * time.Minute).Add(15 * time.Minute)
+
+	img.URL = app.signedURL(img.Key, expires)
+	img.ThumbnailURL = app.signedURL(img.ThumbnailKey, expires)
+}
+
+func (app *application) signedURL(key string, expires time.Time) string {
+	qs := url.Values{}
+	qs.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	qs.Set("signature", app.signer.Sign(key, expires))
+
+	return "/v1/images/" + key + "?" + qs.Encode()
+}
+
+// The deleteImageFiles() helper removes the stored files for images whose records have
+// been deleted. Failures are only logged, since the record is already gone.
+func (app *application) deleteImageFiles(r *http.Request, images ...*data.MovieImage) {
+	for _, img := range images {
+		for _, key := range []string{img.Key, img.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+
+			err := app.storage.Delete(key)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}
+	}
+}
+
+// sniffContentType works out the type of an uploaded file from its first 512 bytes, and
+// rewinds the file afterwards.
+func sniffContentType(file multipart.File) (string, error) {
+	buf := make([]byte, 512)
+
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(buf[:n]), nil
+}
+
+type encodedThumbnail struct {
+	body      []byte
+	extension string
+}
+
+// makeThumbnail decodes an uploaded image and encodes a thumbnail of it,
+// as a JPEG for photos and a PNG otherwise so that transparency is kept.
+func makeThumbnail(file multipart.File, contentType string) (*encodedThumbnail, error) {
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := resizeImage(src, thumbnailWidth)
+
+	var buf bytes.Buffer
+
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		return &encodedThumbnail{body: buf.Bytes(), extension: ".jpg"}, err
+	}
+
+	err = png.Encode(&buf, thumb)
+	return &encodedThumbnail{body: buf.Bytes(), extension: ".png"}, err
+}
+
+// resizeImage scales an image down to the given width, keeping its aspect ratio, by
+// averaging the block of source pixels behind each destination pixel. Images which are
+// already narrow enough are returned unchanged.
+func resizeImage(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		return src
+	}
+
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	dst := image.NewRGBA64(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+
+			var r, g, b, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
+
+// randomName returns a random hex string for naming stored files, so that each upload
+// gets a new key and cached copies of an old image are never served in its place.
+func randomName() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+const (
+	// Import files are held in memory while the background job runs, so keep them to a
+	// sensible size.
+	maxImportBytes = 10 * 1_048_576
+
+	// In partial mode, rows are committed in batches of this size so that progress is
+	// visible through the status endpoint while the import runs.
+	importBatchSize = 500
+)
+
+// importRow is a movie parsed from an import file, along with the line it came from.
+type importRow struct {
+	line  int
+	movie *data.Movie
+}
+
+// POST /v1/movies/import?mode=atomic|partial
+//
+// The body is a CSV file (Content-Type: text/csv) with a header row naming the title,
+// year, runtime and genres columns, or NDJSON (Content-Type: application/x-ndjson) with
+// one movie object per line. The file is parsed up front and the rows are inserted by a
+// background job, whose progress can be followed at /v1/imports/:id.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	imp := &data.MovieImport{
+		UserID: app.contextGetUser(r).ID,
+		Status: data.ImportStatusPending,
+	}
+
+	qs := r.URL.Query()
+
+	imp.Mode = app.readString(qs, "mode", data.ImportModeAtomic)
+	imp.Format = app.readString(qs, "format", importFormat(r.Header.Get("Content-Type")))
+
+	v := validator.New()
+
+	if data.ValidateMovieImport(v, imp); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badBadRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			app.badBadRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	var rows []importRow
+
+	switch imp.Format {
+	case data.ImportFormatCSV:
+		rows, imp.Errors, err = parseCSVImport(body)
+	case data.ImportFormatNDJSON:
+		rows, imp.Errors = parseNDJSONImport(body)
+	}
+	if err != nil {
+		app.badBadRequestResponse(w, r, err)
+		return
+	}
+
+	imp.TotalRows = len(rows) + len(imp.Errors)
+	imp.Failed = len(imp.Errors)
+
+	if imp.TotalRows == 0 {
+		app.badBadRequestResponse(w, r, errors.New("body must contain at least one movie"))
+		return
+	}
+
+	err = app.models.MovieImports.Insert(imp)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/imports/%d", imp.ID))
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"import": imp}, headers)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+
+	// Start the job only once the response has been written, as the job goes on to
+	// modify imp.
+	app.background(func() {
+		app.runMovieImport(imp, rows)
+	})
+}
+
+// GET /v1/imports/:id
+//
+// Shows an import's progress. Imports can only be seen by the user who started them;
+// anyone else gets a 404 Not Found, as if the import didn't exist.
+func (app *application) showMovieImportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	imp, err := app.models.MovieImports.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if imp.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"import": imp}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// runMovieImport validates and inserts the parsed rows, recording progress and row-level
+// errors on the import as it goes. It is run in the background by importMoviesHandler.
+func (app *application) runMovieImport(imp *data.MovieImport, rows []importRow) {
+	imp.Status = data.ImportStatusRunning
+	app.saveMovieImport(imp)
+
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"import_id": strconv.FormatInt(imp.ID, 10)})
+
+		now := time.Now()
+		imp.Status = data.ImportStatusFailed
+		imp.CompletedAt = &now
+		app.saveMovieImport(imp)
+		return
+	}
+
+	// Validate every row first, so that atomic imports can fail without touching the
+	// database and partial imports only attempt to insert valid rows.
+	var valid []importRow
+
+	for _, row := range rows {
+		v := validator.New()
+		if data.ValidateMovie(v, row.movie, taxonomy); !v.Valid() {
+			imp.Errors = append(imp.Errors, data.ImportRowError{Row: row.line, Errors: v.Errors})
+			imp.Failed++
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	switch imp.Mode {
+	case data.ImportModeAtomic:
+		app.runAtomicMovieImport(imp, valid)
+	case data.ImportModePartial:
+		app.runPartialMovieImport(imp, valid)
+	}
+
+	now := time.Now()
+	imp.CompletedAt = &now
+	app.saveMovieImport(imp)
+
+	app.logger.PrintInfo("movie import finished", map[string]string{
+		"import_id": strconv.FormatInt(imp.ID, 10),
+		"status":    imp.Status,
+		"imported":  strconv.Itoa(imp.Imported),
+		"failed":    strconv.Itoa(imp.Failed),
+	})
+}
+
+func (app *application) runAtomicMovieImport(imp *data.MovieImport, rows []importRow) {
+	if imp.Failed > 0 {
+		imp.Status = data.ImportStatusFailed
+		return
+	}
+
+	movies := make([]*data.Movie, len(rows))
+	for i := range rows {
+		movies[i] = rows[i].movie
+	}
+
+	// Every row has already been validated, so an error here is most likely the database
+	// rather than any one row, and isn't reported against a row.
+	err := app.models.Movies.InsertAll(movies, imp.UserID)
+	if err != nil {
+		imp.Status = data.ImportStatusFailed
+		app.logger.PrintError(err, map[string]string{"import_id": strconv.FormatInt(imp.ID, 10)})
+		return
+	}
+
+	imp.Imported = len(movies)
+	imp.Status = data.ImportStatusCompleted
+}
+
+func (app *application) runPartialMovieImport(imp *data.MovieImport, rows []importRow) {
+	for start := 0; start < len(rows); start += importBatchSize {
+		batch := rows[start:min(start+importBatchSize, len(rows))]
+
+		movies := make([]*data.Movie, len(batch))
+		for i := range batch {
+			movies[i] = batch[i].movie
+		}
+
+		rowErrors, err := app.models.Movies.InsertEach(movies, imp.UserID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"import_id": strconv.FormatInt(imp.ID, 10)})
+			imp.Status = data.ImportStatusFailed
+			return
+		}
+
+		for i, rowErr := range rowErrors {
+			if rowErr != nil {
+				imp.Errors = append(imp.Errors, data.ImportRowError{Row: batch[i].line, Errors: map[string]string{"row": rowErr.Error()}})
+				imp.Failed++
+				continue
+			}
+			imp.Imported++
+		}
+
+		app.saveMovieImport(imp)
+	}
+
+	imp.Status = data.ImportStatusCompleted
+}
+
+func (app *application) saveMovieImport(imp *data.MovieImport) {
+	err := app.models.MovieImports.Update(imp)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"import_id": strconv.FormatInt(imp.ID, 10)})
+	}
+}
+
+// importFormat maps the request's Content-Type onto an import format, returning the
+// empty string if it isn't one we recognise.
+func importFormat(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	switch mediaType {
+	case "text/csv":
+		return data.ImportFormatCSV
+	case "application/x-ndjson", "application/jsonl", "application/json-lines":
+		return data.ImportFormatNDJSON
+	default:
+		return ""
+	}
+}
+
+// parseCSVImport reads movies from a CSV file with a header row. Genres are given as a
+// comma-separated list in a single (quoted) field, and runtime may be either a number of
+// minutes or the "<n> mins" format used by the JSON API. An error is only returned if
+// the file as a whole can't be read; problems with individual rows are reported as row
+// errors.
+func parseCSVImport(body []byte) ([]importRow, []data.ImportRowError, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validator.PermittedValue(name, "title", "year", "runtime", "genres") {
+			return nil, nil, fmt.Errorf("CSV header contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+
+	var rows []importRow
+	var rowErrors []data.ImportRowError
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			var parseError *csv.ParseError
+			if !errors.As(err, &parseError) {
+				return nil, nil, err
+			}
+			rowErrors = append(rowErrors, data.ImportRowError{Row: parseError.Line, Errors: map[string]string{"row": parseError.Err.Error()}})
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		if len(record) != len(header) {
+			rowErrors = append(rowErrors, data.ImportRowError{Row: line, Errors: map[string]string{"row": "wrong number of fields"}})
+			continue
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		movie := &data.Movie{Title: field("title")}
+		v := validator.New()
+
+		if s := field("year"); s != "" {
+			year, err := strconv.ParseInt(s, 10, 32)
+			v.Check(err == nil, "year", "must be an integer value")
+			movie.Year = int32(year)
+		}
+
+		if s := field("runtime"); s != "" {
+			runtime, err := parseImportRuntime(s)
+			v.Check(err == nil, "runtime", "must be a number of minutes")
+			movie.Runtime = runtime
+		}
+
+		if s := field("genres"); s != "" {
+			for _, genre := range strings.Split(s, ",") {
+				movie.Genres = append(movie.Genres, strings.TrimSpace(genre))
+			}
+		}
+
+		if !v.Valid() {
+			rowErrors = append(rowErrors, data.ImportRowError{Row: line, Errors: v.Errors})
+			continue
+		}
+
+		rows = append(rows, importRow{line: line, movie: movie})
+	}
+
+	return rows, rowErrors, nil
+}
+
+// parseNDJSONImport reads movies from newline-delimited JSON, one object per line, using
+// the same fields as POST /v1/movies. Blank lines are skipped.
+func parseNDJSONImport(body []byte) ([]importRow, []data.ImportRowError) {
+	var rows []importRow
+	var rowErrors []data.ImportRowError
+
+	for i, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err == nil && dec.More() {
+			err = errors.New("line must contain a single JSON value")
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, data.ImportRowError{Row: i + 1, Errors: map[string]string{"row": err.Error()}})
+			continue
+		}
+
+		rows = append(rows, importRow{
+			line: i + 1,
+			movie: &data.Movie{
+				Title:   input.Title,
+				Year:    input.Year,
+				Runtime: input.Runtime,
+				Genres:  input.Genres,
+			},
+		})
+	}
+
+	return rows, rowErrors
+}
+
+// parseImportRuntime accepts either a plain number of minutes or the "<n> mins" format.
+func parseImportRuntime(s string) (data.Runtime, error) {
+	if i, err := strconv.ParseInt(s, 10, 32); err == nil {
+		return data.Runtime(i), nil
+	}
+
+	var runtime data.Runtime
+	err := runtime.UnmarshalJSON([]byte(strconv.Quote(s)))
+	return runtime, err
+}
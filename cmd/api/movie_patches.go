@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/jsonpatch"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// The media types accepted by PATCH /v1/movies/:id. A plain application/json body is
+// treated as a merge patch, which is how partial updates worked before the patch formats
+// were supported.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+var (
+	// errUnsupportedPatchType is returned by readMoviePatch() when the request's
+	// Content-Type isn't one of the patch formats.
+	errUnsupportedPatchType = errors.New("unsupported patch type")
+
+	// errInvalidPatchResult is returned by readMoviePatch() when the patch applies but
+	// the result isn't a movie, such as when it adds an unknown key.
+	errInvalidPatchResult = errors.New("the patched movie is invalid")
+)
+
+// movieInput holds the editable representation of a movie, as sent in the body of a PUT
+// request or produced by applying a patch. The id and version can be left out, but if
+// they are given they must match the movie being updated.
+type movieInput struct {
+	ID      *int64       `json:"id"`
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+	Version *int32       `json:"version"`
+}
+
+// apply replaces the editable fields of movie with those from the input. A version which
+// differs from the movie's means the input was based on an earlier version, so
+// ErrEditConflict is returned.
+func (input movieInput) apply(v *validator.Validator, movie *data.Movie) error {
+	if input.ID != nil {
+		v.Check(*input.ID == movie.ID, "id", "must match the id in the URL")
+	}
+	if input.Version != nil && *input.Version != movie.Version {
+		return data.ErrEditConflict
+	}
+
+	movie.Title = input.Title
+	movie.Year = input.Year
+	movie.Runtime = input.Runtime
+	movie.Genres = input.Genres
+
+	return nil
+}
+
+// The movieDocument() helper returns the JSON document which patches are applied to:
+// the editable fields of the movie, with the runtime written in format so that test
+// operations can compare against what the client was shown.
+func movieDocument(movie *data.Movie, format data.RuntimeFormat) ([]byte, error) {
+	runtime, err := movie.Runtime.MarshalFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	genres := movie.Genres
+	if genres == nil {
+		genres = []string{}
+	}
+
+	return json.Marshal(map[string]any{
+		"id":      movie.ID,
+		"title":   movie.Title,
+		"year":    movie.Year,
+		"runtime": json.RawMessage(runtime),
+		"genres":  genres,
+		"version": movie.Version,
+	})
+}
+
+// The readMoviePatch() helper reads a patch from the request body, choosing the format
+// from the Content-Type header, and applies it to the movie. The result is decoded into
+// a movieInput, which still needs to be applied and validated.
+func (app *application) readMoviePatch(w http.ResponseWriter, r *http.Request, movie *data.Movie, format data.RuntimeFormat) (movieInput, error) {
+	var input movieInput
+
+	mediaType := mergePatchType
+
+	if header := r.Header.Get("Content-Type"); header != "" {
+		var err error
+
+		mediaType, _, err = mime.ParseMediaType(header)
+		if err != nil {
+			return input, errUnsupportedPatchType
+		}
+	}
+
+	var apply func(doc, patch []byte) ([]byte, error)
+
+	switch mediaType {
+	case mergePatchType, "application/json":
+		apply = jsonpatch.MergePatch
+	case jsonPatchType:
+		apply = jsonpatch.Apply
+	default:
+		return input, errUnsupportedPatchType
+	}
+
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return input, fmt.Errorf("%w: body must not be larger than %d bytes", jsonpatch.ErrInvalidPatch, maxBytesError.Limit)
+		}
+		return input, err
+	}
+
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return input, fmt.Errorf("%w: body must not be empty", jsonpatch.ErrInvalidPatch)
+	}
+
+	doc, err := movieDocument(movie, format)
+	if err != nil {
+		return input, err
+	}
+
+	doc, err = apply(doc, patch)
+	if err != nil {
+		return input, err
+	}
+
+	err = app.decodeJSON(bytes.NewReader(doc), &input)
+	if err != nil {
+		return input, fmt.Errorf("%w: %v", errInvalidPatchResult, err)
+	}
+
+	return input, nil
+}
+
+// The moviePatchErrorResponse() helper sends the response for an error returned by
+// readMoviePatch().
+func (app *application) moviePatchErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedPatchType):
+		app.unsupportedPatchTypeResponse(w, r)
+	case errors.Is(err, errInvalidPatchResult):
+		app.failedValidationResponse(w, r, map[string]string{"patch": err.Error()})
+	case errors.Is(err, jsonpatch.ErrInvalidPatch):
+		app.badBadRequestResponse(w, r, err)
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		app.preconditionFailedResponse(w, r)
+	case errors.Is(err, jsonpatch.ErrConflict):
+		app.patchConflictResponse(w, r, err)
+	default:
+		app.serveErrorResponse(w, r, err)
+	}
+}
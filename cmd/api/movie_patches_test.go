@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/jsonlog"
+)
+
+func TestReadMoviePatch(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelInfo)}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantTitle   string
+	}{
+		{
+			name:        "merge patch",
+			contentType: "application/merge-patch+json",
+			body:        `{"title":"Moana (2016)"}`,
+			wantTitle:   "Moana (2016)",
+		},
+		{
+			name:        "plain JSON is a merge patch",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"title":"Moana (2016)"}`,
+			wantTitle:   "Moana (2016)",
+		},
+		{
+			name:        "JSON patch",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"test","path":"/runtime","value":"107 mins"},{"op":"replace","path":"/title","value":"Moana (2016)"}]`,
+			wantTitle:   "Moana (2016)",
+		},
+		{
+			name:        "unsupported type",
+			contentType: "text/plain",
+			body:        `title=Moana`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "malformed content type",
+			contentType: "application/json; =",
+			body:        `{}`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "empty body",
+			contentType: "application/merge-patch+json",
+			body:        ` `,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "malformed JSON patch",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"frobnicate","path":"/title"}]`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "result with an unknown key",
+			contentType: "application/merge-patch+json",
+			body:        `{"director":"Ron Clements"}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "result with the wrong type",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"replace","path":"/year","value":"2016"}]`,
+			wantStatus:  http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "failed test operation",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"test","path":"/version","value":2},{"op":"replace","path":"/title","value":"Moana (2016)"}]`,
+			wantStatus:  http.StatusPreconditionFailed,
+		},
+		{
+			name:        "conflict",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"remove","path":"/genres/5"}]`,
+			wantStatus:  http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := &data.Movie{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 3}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPatch, "/v1/movies/1", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+
+			input, err := app.readMoviePatch(w, r, movie, data.RuntimeFormatMins)
+
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if input.Title != tt.wantTitle {
+					t.Errorf("title = %q; want %q", input.Title, tt.wantTitle)
+				}
+				if input.Version == nil || *input.Version != movie.Version {
+					t.Errorf("version = %v; want %d", input.Version, movie.Version)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("got %+v; want an error", input)
+			}
+
+			app.moviePatchErrorResponse(w, r, err)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusUnsupportedMediaType && w.Header().Get("Accept-Patch") == "" {
+				t.Error("415 response has no Accept-Patch header")
+			}
+		})
+	}
+}
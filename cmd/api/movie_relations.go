@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// GET /v1/movies/:id/relations
+func (app *application) listMovieRelationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.movieExists(w, r, id) {
+		return
+	}
+
+	relations, err := app.models.Relations.GetForMovie(id)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"relations": relations}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// PUT /v1/movies/:id/relations/:related_id
+//
+// Relates the movie to an earlier one, e.g. {"kind": "sequel_of"} marks it as the sequel
+// of :related_id.
+func (app *application) putMovieRelationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	relatedID, err := app.readInt64Param(r, "related_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Kind string `json:"kind"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badBadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovieRelation(v, id, relatedID, input.Kind); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Relations.Set(id, relatedID, input.Kind)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownMovie):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateRelation):
+			v.AddError("related_id", "is already related to this movie the other way round")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	relations, err := app.models.Relations.GetForMovie(id)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"relations": relations}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/movies/:id/relations/:related_id
+func (app *application) deleteMovieRelationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	relatedID, err := app.readInt64Param(r, "related_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Relations.Delete(id, relatedID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "relation successfully deleted"}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
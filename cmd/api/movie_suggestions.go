@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"greenlight.natenine.com/internal/validator"
+)
+
+// GET /v1/movies/suggest?q=godfa&limit=10
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Q     string
+		Limit int
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Q = strings.TrimSpace(app.readString(qs, "q", ""))
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	v.Check(input.Q != "", "q", "must be provided")
+	v.Check(len(input.Q) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(input.Limit > 0, "limit", "must be greater than zero")
+	v.Check(input.Limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Suggest(input.Q, input.Limit)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
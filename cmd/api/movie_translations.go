@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// GET /v1/movies/:id/translations
+func (app *application) listMovieTranslationsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.movieExists(w, r, id) {
+		return
+	}
+
+	translations, err := app.models.Translations.GetForMovies([]int64{id})
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	if translations[id] == nil {
+		translations[id] = []*data.MovieTranslation{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"translations": translations[id]}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// PUT /v1/movies/:id/translations/:lang
+//
+// Saves the title and synopsis of a movie in the language given by a BCP 47 tag,
+// replacing any existing translation into that language.
+func (app *application) putMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Title    string `json:"title"`
+		Synopsis string `json:"synopsis"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badBadRequestResponse(w, r, err)
+		return
+	}
+
+	tr := &data.MovieTranslation{
+		MovieID:  id,
+		Language: httprouter.ParamsFromContext(r.Context()).ByName("lang"),
+		Title:    input.Title,
+		Synopsis: input.Synopsis,
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovieTranslation(v, tr); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tr.Language, _ = data.CanonicalLanguageTag(tr.Language)
+
+	if !app.movieExists(w, r, id) {
+		return
+	}
+
+	err = app.models.Translations.Upsert(tr)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"translation": tr}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/movies/:id/translations/:lang
+func (app *application) deleteMovieTranslationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	language, ok := data.CanonicalLanguageTag(httprouter.ParamsFromContext(r.Context()).ByName("lang"))
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Translations.Delete(id, language)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "translation successfully deleted"}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/movies/:id/releases
+func (app *application) listMovieReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if !app.movieExists(w, r, id) {
+		return
+	}
+
+	releases, err := app.models.Releases.GetForMovies([]int64{id})
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	if releases[id] == nil {
+		releases[id] = []*data.MovieRelease{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"releases": releases[id]}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// PUT /v1/movies/:id/releases/:country
+//
+// Saves the release date and age certification of a movie in a country, given by its
+// ISO 3166-1 alpha-2 code.
+func (app *application) putMovieReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ReleaseDate   string `json:"release_date"`
+		Certification string `json:"certification"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badBadRequestResponse(w, r, err)
+		return
+	}
+
+	release := &data.MovieRelease{
+		MovieID:       id,
+		Country:       strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("country")),
+		ReleaseDate:   input.ReleaseDate,
+		Certification: input.Certification,
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovieRelease(v, release); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.movieExists(w, r, id) {
+		return
+	}
+
+	err = app.models.Releases.Upsert(release)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"release": release}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/movies/:id/releases/:country
+func (app *application) deleteMovieReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	country := strings.ToUpper(httprouter.ParamsFromContext(r.Context()).ByName("country"))
+
+	err = app.models.Releases.Delete(id, country)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "release successfully deleted"}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
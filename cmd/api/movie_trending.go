@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// trendingWindows are the periods which trending movies can be listed for.
+var trendingWindows = map[string]time.Duration{
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+// GET /v1/movies/trending?window=day|week
+//
+// Lists the movies viewed most over the last day or week. The view counts are estimated
+// from a sample, and views from the last -views-flush-interval may not be counted yet.
+func (app *application) trendingMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	window := app.readString(qs, "window", "week")
+	limit := app.readInt(qs, "limit", 20, v)
+	languages := app.readLanguages(r, v)
+
+	_, ok := trendingWindows[window]
+	v.Check(ok, "window", "must be day or week")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 100, "limit", "must not be more than 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	trending, err := app.models.Popularity.Trending(time.Now().Add(-trendingWindows[window]), limit)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	movies := make([]*data.Movie, len(trending))
+	for i, tm := range trending {
+		movies[i] = tm.Movie
+	}
+
+	_, err = app.localizeMovies(movies, languages)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"window": window, "trending": trending}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// movieView describes how movies should be represented in a response: which of their
+// fields to include (all of them if fields is empty), which related resources to embed
+// in each movie, and how to write their runtimes.
+type movieView struct {
+	fields        []string
+	include       []string
+	runtimeFormat data.RuntimeFormat
+}
+
+// plain reports whether the view leaves movies in their default representation.
+func (view movieView) plain() bool {
+	return len(view.fields) == 0 && len(view.include) == 0 && view.runtimeFormat == data.RuntimeFormatMins
+}
+
+// movieIncluder loads a related resource for each of a set of movies, keyed by movie id.
+type movieIncluder func(movieIDs []int64) (map[int64]any, error)
+
+// movieIncluders returns the related resources which can be embedded in movie responses
+// with ?include=, keyed by the name used in the query string and response.
+func (app *application) movieIncluders() map[string]movieIncluder {
+	return map[string]movieIncluder{
+		"versions": func(movieIDs []int64) (map[int64]any, error) {
+			versions, err := app.models.MovieVersions.GetForMovies(movieIDs)
+			if err != nil {
+				return nil, err
+			}
+
+			included := make(map[int64]any, len(movieIDs))
+			for _, id := range movieIDs {
+				if versions[id] == nil {
+					versions[id] = []*data.MovieVersion{}
+				}
+				included[id] = versions[id]
+			}
+			return included, nil
+		},
+		"images": func(movieIDs []int64) (map[int64]any, error) {
+			images, err := app.models.MovieImages.GetForMovies(movieIDs)
+			if err != nil {
+				return nil, err
+			}
+
+			included := make(map[int64]any, len(movieIDs))
+			for _, id := range movieIDs {
+				if images[id] == nil {
+					images[id] = []*data.MovieImage{}
+				}
+				for _, img := range images[id] {
+					app.signImage(img)
+				}
+				included[id] = images[id]
+			}
+			return included, nil
+		},
+		"translations": func(movieIDs []int64) (map[int64]any, error) {
+			translations, err := app.models.Translations.GetForMovies(movieIDs)
+			if err != nil {
+				return nil, err
+			}
+
+			included := make(map[int64]any, len(movieIDs))
+			for _, id := range movieIDs {
+				if translations[id] == nil {
+					translations[id] = []*data.MovieTranslation{}
+				}
+				included[id] = translations[id]
+			}
+			return included, nil
+		},
+		"releases": func(movieIDs []int64) (map[int64]any, error) {
+			releases, err := app.models.Releases.GetForMovies(movieIDs)
+			if err != nil {
+				return nil, err
+			}
+
+			included := make(map[int64]any, len(movieIDs))
+			for _, id := range movieIDs {
+				if releases[id] == nil {
+					releases[id] = []*data.MovieRelease{}
+				}
+				included[id] = releases[id]
+			}
+			return included, nil
+		},
+		"external_ids": func(movieIDs []int64) (map[int64]any, error) {
+			ids, err := app.models.ExternalIDs.GetForMovies(movieIDs)
+			if err != nil {
+				return nil, err
+			}
+
+			included := make(map[int64]any, len(movieIDs))
+			for _, id := range movieIDs {
+				if ids[id] == nil {
+					ids[id] = []data.ExternalID{}
+				}
+				included[id] = ids[id]
+			}
+			return included, nil
+		},
+	}
+}
+
+// The readMovieView() helper reads the fields and include parameters from the query
+// string, along with the runtime format, recording any values which aren't permitted in
+// the Validator.
+func (app *application) readMovieView(r *http.Request, v *validator.Validator) movieView {
+	qs := r.URL.Query()
+
+	view := movieView{
+		fields:        app.readCSV(qs, "fields", []string{}),
+		include:       app.readCSV(qs, "include", []string{}),
+		runtimeFormat: app.readRuntimeFormat(r, v),
+	}
+
+	data.ValidateMovieFields(v, view.fields)
+
+	includers := app.movieIncluders()
+	for _, name := range view.include {
+		_, ok := includers[name]
+		v.Check(ok, "include", "invalid include value")
+	}
+	v.Check(validator.Unique(view.include), "include", "must not contain duplicate values")
+
+	return view
+}
+
+// The readRuntimeFormat() helper reads the format runtimes should be written in, from
+// the runtime_format query string parameter or failing that the Runtime-Format header.
+// Responses whose format can come from the header should add it to the Vary header.
+func (app *application) readRuntimeFormat(r *http.Request, v *validator.Validator) data.RuntimeFormat {
+	format := app.readString(r.URL.Query(), "runtime_format", r.Header.Get("Runtime-Format"))
+	if format == "" {
+		return data.RuntimeFormatMins
+	}
+
+	format = strings.ToLower(strings.TrimSpace(format))
+
+	if !validator.PermittedValue(data.RuntimeFormat(format), data.RuntimeFormats...) {
+		v.AddError("runtime_format", "must be one of mins, minutes or iso8601")
+	}
+
+	return data.RuntimeFormat(format)
+}
+
+// The renderMovies() helper applies a view to a list of movies, returning a value which
+// can be written with writeJSON(). If the view is plain the movies are returned as-is.
+func (app *application) renderMovies(movies []*data.Movie, view movieView) (any, error) {
+	if view.plain() {
+		return movies, nil
+	}
+
+	rendered := make([]map[string]json.RawMessage, len(movies))
+	ids := make([]int64, len(movies))
+
+	for i, movie := range movies {
+		js, err := json.Marshal(movie)
+		if err != nil {
+			return nil, err
+		}
+
+		var all map[string]json.RawMessage
+		err = json.Unmarshal(js, &all)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := all["runtime"]; ok && view.runtimeFormat != data.RuntimeFormatMins {
+			all["runtime"], err = movie.Runtime.MarshalFormat(view.runtimeFormat)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		rendered[i] = all
+		if len(view.fields) > 0 {
+			rendered[i] = make(map[string]json.RawMessage, len(view.fields))
+			for _, field := range view.fields {
+				if value, ok := all[field]; ok {
+					rendered[i][field] = value
+				}
+			}
+		}
+
+		ids[i] = movie.ID
+	}
+
+	if len(movies) == 0 {
+		return rendered, nil
+	}
+
+	includers := app.movieIncluders()
+
+	for _, name := range view.include {
+		included, err := includers[name](ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, id := range ids {
+			js, err := json.Marshal(included[id])
+			if err != nil {
+				return nil, err
+			}
+			rendered[i][name] = js
+		}
+	}
+
+	return rendered, nil
+}
+
+// The renderMovie() helper applies a view to a single movie.
+func (app *application) renderMovie(movie *data.Movie, view movieView) (any, error) {
+	if view.plain() {
+		return movie, nil
+	}
+
+	rendered, err := app.renderMovies([]*data.Movie{movie}, view)
+	if err != nil {
+		return nil, err
+	}
+
+	return rendered.([]map[string]json.RawMessage)[0], nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"greenlight.natenine.com/internal/data"
 	"greenlight.natenine.com/internal/validator"
@@ -16,6 +20,10 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Year    int32        `json:"year"`
 		Runtime data.Runtime `json:"runtime"`
 		Genres  []string     `json:"genres"`
+
+		// A movie synced from another catalogue is matched on its ids there, so that
+		// syncing it again updates the movie rather than creating a duplicate.
+		ExternalIDs []data.ExternalID `json:"external_ids"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -31,14 +39,52 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Genres:  input.Genres,
 	}
 
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	view := movieView{runtimeFormat: app.readRuntimeFormat(r, v)}
+
+	data.ValidateMovie(v, movie, taxonomy)
+
+	if data.ValidateExternalIDs(v, input.ExternalIDs); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	status := http.StatusCreated
+
+	if len(input.ExternalIDs) == 0 {
+		err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
+	} else {
+		var created bool
+
+		created, err = app.models.Movies.Upsert(movie, input.ExternalIDs, app.contextGetUser(r).ID)
+		if !created {
+			status = http.StatusOK
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrExternalIDConflict):
+			app.externalIDConflictResponse(w, r)
+		default:
+			app.serveErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	rendered, err := app.renderMovie(movie, view)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	etag, err := app.renderedMovieETag(movie, view, rendered)
 	if err != nil {
 		app.serveErrorResponse(w, r, err)
 		return
@@ -46,8 +92,9 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", etag)
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeJSON(w, status, envelope{"movie": rendered}, headers)
 	if err != nil {
 		app.serveErrorResponse(w, r, err)
 	}
@@ -61,30 +108,146 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+
+	view := app.readMovieView(r, v)
+	languages := app.readLanguages(r, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetFields(id, view.fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
+			app.redirectMergedMovie(w, r, id)
 		default:
 			app.serveErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	app.recordView(movie.ID)
+
+	translations, err := app.localizeMovies([]*data.Movie{movie}, languages)
 	if err != nil {
 		app.serveErrorResponse(w, r, err)
+		return
 	}
 
+	rendered, err := app.renderMovie(movie, view)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	// The collections the movie is part of, and the movies either side of it in each,
+	// are shown alongside it so that clients can link to the next and previous films.
+	collections, err := app.models.Collections.GetForMovie(movie.ID)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	relations, err := app.models.Relations.GetForMovie(movie.ID)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"movie": rendered, "collections": collections, "relations": relations}
+
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Add("Vary", "Runtime-Format")
+
+	var body []byte
+
+	if !view.plain() || translations[movie.ID] != nil || len(collections) > 0 || len(relations) > 0 {
+		body, err = json.Marshal(env)
+		if err != nil {
+			app.serveErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	etag := movieETag(movie, body)
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+	if tr := translations[movie.ID]; tr != nil {
+		headers.Set("Content-Language", tr.Language)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+
+}
+
+// PUT /v1/movies/:id replaces the editable fields of a movie with those in the body.
+// Every field must be given, as a missing one is treated as empty.
+func (app *application) replaceMovieHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieForUpdate(w, r)
+	if !ok {
+		return
+	}
+
+	var input movieInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badBadRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	view := movieView{runtimeFormat: app.readRuntimeFormat(r, v)}
+
+	app.saveMovieUpdate(w, r, movie, input, v, view)
 }
 
+// PATCH /v1/movies/:id applies a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902)
+// to a movie, depending on the Content-Type. Test operations in a JSON Patch are checked
+// against the movie as it is stored, with the runtime in the requested format.
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the movie ID from the URL.
+	movie, ok := app.readMovieForUpdate(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	// The runtime format has to be checked before the patch is applied, as the document
+	// being patched is written in it.
+	view := movieView{runtimeFormat: app.readRuntimeFormat(r, v)}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	input, err := app.readMoviePatch(w, r, movie, view.runtimeFormat)
+	if err != nil {
+		app.moviePatchErrorResponse(w, r, err)
+		return
+	}
+
+	app.saveMovieUpdate(w, r, movie, input, v, view)
+}
+
+// The readMovieForUpdate() helper fetches the movie named in the URL and checks it
+// against any If-Match header. If it returns false a response has already been sent.
+func (app *application) readMovieForUpdate(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
-		return
+		return nil, false
 	}
 
 	// Fetch the existing movie record from the database, sending a 404 Not Found response to the client if we couldn't find a matching record.
@@ -96,56 +259,76 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		default:
 			app.serveErrorResponse(w, r, err)
 		}
-		return
+		return nil, false
 	}
 
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	// An If-Match header lets the client make sure it is editing the version it last
+	// fetched, rather than whatever is now current.
+	if !app.ifMatch(r, movie.Version) {
+		app.preconditionFailedResponse(w, r)
+		return nil, false
 	}
 
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badBadRequestResponse(w, r, err)
-	}
+	return movie, true
+}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
+// The saveMovieUpdate() helper applies the input to the movie, validates and saves the
+// result, and sends the updated movie in the response.
+func (app *application) saveMovieUpdate(w http.ResponseWriter, r *http.Request, movie *data.Movie, input movieInput, v *validator.Validator, view movieView) {
+	conflict := func() {
+		if r.Header.Get("If-Match") != "" {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+		app.editConflictResponse(w, r)
 	}
 
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+	err := input.apply(v, movie)
+	if err != nil {
+		conflict()
+		return
 	}
 
 	// Validate the updated movie record, sending the client a 422 Unprocessable Entity response if any checks fail.
-	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateMovie(v, movie, taxonomy); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// Pass the updated movie record to our new Update() method.
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
+			conflict()
 		default:
 			app.serveErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	rendered, err := app.renderMovie(movie, view)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	etag, err := app.renderedMovieETag(movie, view, rendered)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": rendered}, headers)
 	if err != nil {
 		app.serveErrorResponse(w, r, err)
 	}
@@ -158,60 +341,193 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	// With an If-Match header the movie is only deleted if it is still at the version the
+	// ETag refers to. Passing that version on to Delete() covers the case where it changes
+	// between the check and the delete.
+	var version int32
+
+	if r.Header.Get("If-Match") != "" {
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serveErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !app.ifMatch(r, movie.Version) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+		version = movie.Version
+	}
+
+	// The image records are removed along with the movie, so look them up first to
+	// find the stored files which need deleting too.
+	images, err := app.models.MovieImages.GetForMovies([]int64{id})
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Movies.Delete(id, version)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serveErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	app.deleteImageFiles(r, images[id]...)
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serveErrorResponse(w, r, err)
 	}
 }
 
-// Search works like this => "GET /v1/movies?title=nameOfMovie"
+// Search works like this => "GET /v1/movies?title=nameOfMovie&sort=relevance"
+// The title search accepts web search syntax, e.g. title="the godfather" OR casablanca -part
 func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// To keep things consistent with our other handlers, we'll define an input struct to hold the expected values from the request query string.
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilters
 		data.Filters
+		Facets []string
+		View   movieView
+	}
+
+	taxonomy, err := app.models.Genres.Taxonomy()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
 	}
 
 	v := validator.New()
 
 	qs := r.URL.Query()
 
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.MovieFilters = app.readMovieFilters(qs, v)
+	input.Facets = app.readCSV(qs, "facets", []string{})
+	input.View = app.readMovieView(r, v)
+
+	// Titles are searched, and shown, in the client's preferred language.
+	languages := app.readLanguages(r, v)
+	if len(languages) > 0 {
+		input.MovieFilters.Language = languages[0]
+	}
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+
+	// Counting every match is what makes deep pages slow, so when paging by cursor the
+	// total is only included if the client asks for it.
+	cursorPaging := input.Filters.After != "" || input.Filters.Before != ""
+	input.Filters.IncludeTotal = app.readBool(qs, "include_total", !cursorPaging, v)
+
+	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "relevance", "popularity", "-id", "-title", "-year", "-runtime", "-relevance", "-popularity"}
+
+	data.ValidateMovieFilters(v, &input.MovieFilters, taxonomy)
+	data.ValidateFacets(v, input.Facets)
+
+	if strings.TrimPrefix(input.Filters.Sort, "-") == "relevance" {
+		v.Check(input.MovieFilters.Title != "", "sort", "relevance sort requires a title search")
+	}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilters, input.Filters, input.View.fields)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	app.setPageLinks(r, &metadata)
+
+	_, err = app.localizeMovies(movies, languages)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Add("Vary", "Runtime-Format")
+
+	rendered, err := app.renderMovies(movies, input.View)
 	if err != nil {
 		app.serveErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "movies": movies}, nil)
+	env := envelope{"metadata": metadata, "movies": rendered}
+
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Movies.Facets(input.MovieFilters, input.Facets)
+		if err != nil {
+			app.serveErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+
+	// The listing depends on every movie it matches, so the ETag is taken from the body
+	// itself. It is weak because only the content, not the exact bytes, is compared.
+	js, err := json.Marshal(env)
 	if err != nil {
 		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	etag := weakETag(js)
+	if app.notModified(w, r, etag) {
+		return
 	}
 
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+
+}
+
+// The readMovieFilters() helper reads the movie filters shared by the listing and export
+// endpoints from the query string. Values which can't be parsed are recorded in the
+// Validator; the caller still needs to call data.ValidateMovieFilters().
+func (app *application) readMovieFilters(qs url.Values, v *validator.Validator) data.MovieFilters {
+	var f data.MovieFilters
+
+	f.Title = app.readString(qs, "title", "")
+
+	f.Genres = app.readCSV(qs, "genres", []string{})
+	f.GenresAny = app.readCSV(qs, "genres_any", []string{})
+	f.ExcludeGenres = app.readCSV(qs, "exclude_genres", []string{})
+
+	f.YearMin = int32(app.readInt(qs, "year_min", 0, v))
+	f.YearMax = int32(app.readInt(qs, "year_max", 0, v))
+	f.RuntimeMin = int32(app.readInt(qs, "runtime_min", 0, v))
+	f.RuntimeMax = int32(app.readInt(qs, "runtime_max", 0, v))
+
+	f.CreatedAfter = app.readTime(qs, "created_after", time.Time{}, v)
+	f.CreatedBefore = app.readTime(qs, "created_before", time.Time{}, v)
+
+	return f
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// GET /v1/users/me/recommendations
+//
+// Lists the movies recommended for the current user, best first. Recommendations are
+// worked out from the user's ratings, watchlist and history by a background job which
+// runs every -recommendations-interval, so new activity shows up after the next run.
+func (app *application) listRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-score")
+	filters.SortSafeList = []string{"score", "-score"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recommendations, metadata, err := app.models.Recommendations.GetForUser(app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "recommendations": recommendations}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
@@ -13,23 +13,116 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
+	// Routes with a rate limiting budget of their own are served from a separate router,
+	// ahead of the global rate limiter. The suggest endpoint is called on every keystroke,
+	// so it gets a larger budget than the rest of the API.
+	budgeted := httprouter.New()
+
+	suggestLimiter := newClientLimiter(app.config.limiter.suggestRPS, app.config.limiter.suggestBurst)
+	budgeted.Handler(http.MethodGet, "/v1/movies/suggest", app.rateLimiter(suggestLimiter, app.authenticate(app.requirePermission("movies:read", app.suggestMoviesHandler))))
+
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
 
 	// Movie - Users that are not authenticated can not access these routes
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMovieHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.idempotent(app.createMovieHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.movieActions(map[string]http.HandlerFunc{
+		"import": app.requirePermission("movies:write", app.importMoviesHandler),
+		"batch":  app.requirePermission("movies:write", app.batchMoviesHandler),
+	}, app.methodNotAllowedResponse))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.movieActions(map[string]http.HandlerFunc{
+		"export":     app.requirePermission("movies:export", app.exportMoviesHandler),
+		"lookup":     app.requirePermission("movies:read", app.lookupMovieHandler),
+		"duplicates": app.requirePermission("movies:write", app.listDuplicateMoviesHandler),
+		"trending":   app.requirePermission("movies:read", app.trendingMoviesHandler),
+		"events":     app.requirePermission("movies:read", app.movieEventsHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.requirePermission("movies:write", app.replaceMovieHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
 
+	// Movie revision history
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/versions", app.requirePermission("movies:read", app.listMovieVersionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/versions/:version", app.requirePermission("movies:read", app.showMovieVersionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/versions/:version/revert", app.requirePermission("movies:write", app.revertMovieHandler))
+
+	// Merging duplicates
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/merge", app.requirePermission("movies:write", app.mergeMovieHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission("movies:read", app.similarMoviesHandler))
+
+	// Ratings
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/rating", app.requirePermission("movies:read", app.rateMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/rating", app.requirePermission("movies:read", app.deleteMovieRatingHandler))
+
+	// Translations and per-country releases
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/translations", app.requirePermission("movies:read", app.listMovieTranslationsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/translations/:lang", app.requirePermission("movies:write", app.putMovieTranslationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/translations/:lang", app.requirePermission("movies:write", app.deleteMovieTranslationHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/releases", app.requirePermission("movies:read", app.listMovieReleasesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/releases/:country", app.requirePermission("movies:write", app.putMovieReleaseHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/releases/:country", app.requirePermission("movies:write", app.deleteMovieReleaseHandler))
+
+	// Relations between movies, such as sequels and remakes
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/relations", app.requirePermission("movies:read", app.listMovieRelationsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/relations/:related_id", app.requirePermission("movies:write", app.putMovieRelationHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/relations/:related_id", app.requirePermission("movies:write", app.deleteMovieRelationHandler))
+
+	// Movie images
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/images", app.requirePermission("movies:read", app.listMovieImagesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/images", app.requirePermission("movies:write", app.uploadMovieImageHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/images/:image_id", app.requirePermission("movies:read", app.showMovieImageHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/images/:image_id", app.requirePermission("movies:write", app.deleteMovieImageHandler))
+
+	// Uploaded files are served to anyone holding a signed link.
+	router.HandlerFunc(http.MethodGet, "/v1/images/*key", app.serveImageHandler)
+
+	// Movie imports
+	router.HandlerFunc(http.MethodGet, "/v1/imports/:id", app.requirePermission("movies:write", app.showMovieImportHandler))
+
+	// Collections
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.requirePermission("movies:read", app.listCollectionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requirePermission("movies:write", app.createCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.requirePermission("movies:read", app.showCollectionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requirePermission("movies:write", app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requirePermission("movies:write", app.deleteCollectionHandler))
+
+	// Genres
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("movies:write", app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:slug", app.requirePermission("movies:read", app.showGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:slug", app.requirePermission("movies:write", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:slug", app.requirePermission("movies:write", app.deleteGenreHandler))
+
+	// Catalogue statistics
+	router.HandlerFunc(http.MethodGet, "/v1/stats/movies", app.requirePermission("stats:read", app.movieStatsHandler))
+
 	// User
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.idempotent(app.registerUserHandler))
 
 	// User activation
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
 
 	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHander)
 
+	// The current user's activity, and the recommendations worked out from it
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/watchlist/:id", app.requirePermission("movies:read", app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id", app.requirePermission("movies:read", app.removeFromWatchlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/history", app.requirePermission("movies:read", app.listWatchHistoryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/history", app.requirePermission("movies:read", app.recordWatchHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/recommendations", app.requirePermission("movies:read", app.listRecommendationsHandler))
+
+	// Webhooks
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("webhooks:manage", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("webhooks:manage", app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("webhooks:manage", app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("webhooks:manage", app.listWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries/:delivery_id", app.requirePermission("webhooks:manage", app.showWebhookDeliveryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/redeliver", app.requirePermission("webhooks:manage", app.redeliverWebhookDeliveryHandler))
+
 	// Authentication endpoint
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 
@@ -38,5 +131,25 @@ func (app *application) routes() http.Handler {
 	// Metrics Endpoint
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
-	return app.metrics(app.recoverPanic(app.enableCors(app.rateLimiter(app.authenticate(router)))))
+	limiter := newClientLimiter(app.config.limiter.rps, app.config.limiter.burst)
+
+	return app.metrics(app.recoverPanic(app.enableCors(app.budgetedRoutes(budgeted, app.rateLimiter(limiter, app.authenticate(router))))))
+}
+
+// httprouter doesn't allow a static path segment in the same position as a named
+// parameter, so routes such as /v1/movies/import can't be registered alongside
+// /v1/movies/:id. Instead, movieActions() is registered for the /v1/movies/:id pattern
+// and looks the value of the :id parameter up in actions, falling back to next if it
+// doesn't name an action.
+func (app *application) movieActions(actions map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if action, ok := actions[params.ByName("id")]; ok {
+			action(w, r)
+			return
+		}
+
+		next(w, r)
+	}
 }
@@ -22,6 +22,10 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// Event streams stay open until the client goes away, so they have to be ended for
+	// the shutdown to finish.
+	srv.RegisterOnShutdown(app.movieEvents.close)
+
 	// Create a shutdown channel
 	shutdownError := make(chan error)
 
@@ -47,6 +51,9 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		// Tell the periodic jobs to stop once they've finished their current run.
+		close(app.shutdown)
+
 		// Call wait to block until the waitgroup counter is zero. Essentially blocking
 		// until the background goroutines have finished. Then we return nil on the shutdownError channel,
 		// to indicate the shutdown is completed without any issues.
@@ -55,12 +62,43 @@ func (app *application) serve() error {
 
 	}()
 
+	app.startViewFlusher()
+
+	err := app.startMovieEventListener()
+	if err != nil {
+		return err
+	}
+
+	app.periodic("movie events", time.Hour, func() error {
+		return app.models.MovieEvents.DeleteExpired(app.config.events.retention)
+	})
+
+	app.startWebhookDispatcher()
+
+	app.periodic("webhook deliveries cleanup", time.Hour, func() error {
+		return app.models.WebhookDeliveries.DeleteCompleted(app.config.webhooks.retention)
+	})
+
+	if app.config.popularity.interval > 0 {
+		app.periodic("popularity", app.config.popularity.interval, func() error {
+			return app.models.Popularity.Refresh(app.config.popularity.halfLife)
+		})
+	}
+
+	app.periodic("idempotency keys", time.Hour, func() error {
+		return app.models.IdempotencyKeys.DeleteExpired(app.config.idempotency.ttl)
+	})
+
+	if app.config.recommendations.interval > 0 {
+		app.periodic("recommendations", app.config.recommendations.interval, app.models.Recommendations.Refresh)
+	}
+
 	app.logger.PrintInfo("starting server ", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
 	})
 
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
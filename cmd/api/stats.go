@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+)
+
+// GET /v1/stats/movies
+//
+// Summarises the catalogue: totals, counts per genre, year and decade, the spread of
+// runtimes and recent activity. The numbers are cached for -stats-cache-ttl, and
+// generated_at in the response says when they were worked out.
+func (app *application) movieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.models.Movies.GetStats()
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serveErrorResponse(w, r, err)
+	}
+}
@@ -74,7 +74,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Email string `json:email`
+		Email string `json:"email"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// viewBuffer collects movie views in memory between flushes to the database, so that
+// showing a movie doesn't cost a write. Views are summed per movie, so the buffer's size
+// depends on how many different movies were viewed rather than on the number of views.
+type viewBuffer struct {
+	mu     sync.Mutex
+	counts map[int64]float64
+	size   int
+
+	// full is signalled when the buffer holds size movies, to flush it early.
+	full chan struct{}
+}
+
+func newViewBuffer(size int) *viewBuffer {
+	return &viewBuffer{
+		counts: make(map[int64]float64),
+		size:   size,
+		full:   make(chan struct{}, 1),
+	}
+}
+
+func (b *viewBuffer) add(id int64, views float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.counts[id] += views
+
+	if len(b.counts) >= b.size {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take empties the buffer, returning the views it held.
+func (b *viewBuffer) take() map[int64]float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	counts := b.counts
+	b.counts = make(map[int64]float64)
+	return counts
+}
+
+// The recordView() helper counts a view of a movie towards its popularity. Only a sample
+// of views is kept, set by -views-sample-rate, and each one kept stands in for the views
+// which weren't.
+func (app *application) recordView(id int64) {
+	rate := app.config.popularity.sampleRate
+	if rate < 1 && rand.Float64() >= rate {
+		return
+	}
+
+	app.views.add(id, 1/rate)
+}
+
+// The startViewFlusher() helper starts the background goroutine which writes buffered
+// views to the database every -views-flush-interval, or sooner if the buffer fills up.
+// When the server shuts down it flushes whatever is left before returning, and serve()
+// waits for it through app.wg.
+func (app *application) startViewFlusher() {
+	flush := func() {
+		views := app.views.take()
+
+		err := app.models.Popularity.RecordViews(views, time.Now())
+		if err != nil {
+			// The views are dropped rather than put back, so that a database outage
+			// can't make the buffer grow without limit.
+			app.logger.PrintError(err, map[string]string{"job": "views", "movies": fmt.Sprint(len(views))})
+		}
+	}
+
+	app.background(func() {
+		ticker := time.NewTicker(app.config.popularity.flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.shutdown:
+				flush()
+				return
+			case <-ticker.C:
+			case <-app.views.full:
+			}
+
+			flush()
+		}
+	})
+}
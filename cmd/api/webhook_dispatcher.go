@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"greenlight.natenine.com/internal/data"
+	"greenlight.natenine.com/internal/webhook"
+)
+
+// The startWebhookDispatcher() helper sends queued webhook deliveries which are due,
+// every poll interval until the server shuts down. Each run claims deliveries in
+// batches and shares them between the workers, carrying on until the queue has nothing
+// more which is due.
+func (app *application) startWebhookDispatcher() {
+	sender := webhook.NewSender(app.config.webhooks.timeout, app.config.webhooks.allowPrivate)
+
+	workers := app.config.webhooks.workers
+	batchSize := workers * 4
+
+	// A claimed batch stays locked for long enough for every delivery in it to time out,
+	// so that another instance doesn't send them too.
+	lease := time.Duration(batchSize/workers+1)*app.config.webhooks.timeout + time.Minute
+
+	app.periodic("webhook deliveries", app.config.webhooks.pollInterval, func() error {
+		for {
+			deliveries, err := app.models.WebhookDeliveries.ClaimDue(batchSize, lease)
+			if err != nil {
+				return err
+			}
+
+			queue := make(chan *data.DueDelivery)
+
+			var wg sync.WaitGroup
+
+			for range min(workers, len(deliveries)) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for delivery := range queue {
+						app.deliverWebhook(sender, delivery)
+					}
+				}()
+			}
+
+			for _, delivery := range deliveries {
+				queue <- delivery
+			}
+			close(queue)
+			wg.Wait()
+
+			if len(deliveries) < batchSize {
+				return nil
+			}
+
+			select {
+			case <-app.shutdown:
+				return nil
+			default:
+			}
+		}
+	})
+}
+
+// The deliverWebhook() helper makes one attempt at sending a delivery and records how it
+// went. Failed attempts are retried with exponential backoff until the delivery runs out
+// of attempts.
+func (app *application) deliverWebhook(sender webhook.Sender, delivery *data.DueDelivery) {
+	msg := webhook.Message{
+		ID:    delivery.ID,
+		Event: delivery.EventType,
+		Body:  delivery.Payload,
+	}
+
+	attemptedAt := time.Now()
+
+	result, err := sender.Send(context.Background(), delivery.URL, delivery.Secret, msg)
+
+	attempt := &data.WebhookAttempt{
+		AttemptedAt:    attemptedAt,
+		DurationMS:     result.Duration.Milliseconds(),
+		ResponseStatus: result.Status,
+		// Postgres text can't hold NUL bytes or invalid UTF-8, which receivers are free
+		// to send.
+		ResponseBody: strings.ToValidUTF8(strings.ReplaceAll(result.Body, "\x00", ""), "�"),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	succeeded := err == nil && result.OK()
+
+	err = app.models.WebhookDeliveries.RecordAttempt(delivery, attempt, succeeded, app.config.webhooks.maxAttempts, webhook.Backoff(delivery.Attempts+1))
+	if err != nil {
+		// A lost lease means someone else now owns the delivery, such as after it was
+		// redelivered, so there's nothing more to do.
+		if !errors.Is(err, data.ErrLeaseLost) {
+			app.logger.PrintError(err, map[string]string{"job": "webhook deliveries", "url": delivery.URL})
+		}
+		return
+	}
+
+	if delivery.Status == data.DeliveryFailed {
+		app.logger.PrintInfo("webhook delivery failed", map[string]string{
+			"url":      delivery.URL,
+			"event":    delivery.EventType,
+			"attempts": strconv.Itoa(delivery.Attempts),
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// ErrUnknownMovie is returned when a collection or relation refers to a movie which
+// doesn't exist.
+var ErrUnknownMovie = errors.New("unknown movie")
+
+// MaxCollectionMovies is the most movies a collection can hold.
+const MaxCollectionMovies = 500
+
+// MovieSummary identifies a movie listed as part of another resource.
+type MovieSummary struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year,omitempty"`
+}
+
+// CollectionEntry is a movie in a collection. Positions start at 1 and give the order
+// the movies are meant to be watched in.
+type CollectionEntry struct {
+	Position int32 `json:"position"`
+	MovieSummary
+}
+
+// Collection is an ordered group of movies, such as a trilogy or a franchise.
+type Collection struct {
+	ID          int64             `json:"id"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	MovieCount  int               `json:"movie_count"`
+	Movies      []CollectionEntry `json:"movies,omitempty"`
+	Version     int32             `json:"version"`
+}
+
+// MovieCollection describes a collection from the point of view of one of its movies,
+// with the movies before and after it.
+type MovieCollection struct {
+	ID         int64         `json:"id"`
+	Name       string        `json:"name"`
+	Position   int32         `json:"position"`
+	MovieCount int           `json:"movie_count"`
+	Previous   *MovieSummary `json:"previous"`
+	Next       *MovieSummary `json:"next"`
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(collection.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+}
+
+func ValidateCollectionMovies(v *validator.Validator, movieIDs []int64) {
+	v.Check(len(movieIDs) <= MaxCollectionMovies, "movie_ids", fmt.Sprintf("must not contain more than %d movies", MaxCollectionMovies))
+	v.Check(validator.Unique(movieIDs), "movie_ids", "must not contain duplicate values")
+
+	for _, id := range movieIDs {
+		if id < 1 {
+			v.AddError("movie_ids", "must only contain positive ids")
+			break
+		}
+	}
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a collection holding the given movies, in order.
+func (m CollectionModel) Insert(collection *Collection, movieIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO collections (name, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, collection.Name, collection.Description).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = setCollectionMovies(ctx, tx, collection, movieIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get returns a collection along with its movies, in order.
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, description, version
+		FROM collections
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var collection Collection
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.Name,
+		&collection.Description,
+		&collection.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		SELECT cm.position, m.id, m.title, m.year
+		FROM collection_movies cm
+		INNER JOIN movies m ON m.id = cm.movie_id
+		WHERE cm.collection_id = $1
+		ORDER BY cm.position`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	collection.Movies, err = scanCollectionEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+	collection.MovieCount = len(collection.Movies)
+
+	return &collection, nil
+}
+
+// GetAll returns a page of collections, without their movies. If name is set, only
+// collections whose name contains it are returned.
+func (m CollectionModel) GetAll(name string, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), c.id, c.created_at, c.name, c.description, c.version,
+			(SELECT count(*) FROM collection_movies cm WHERE cm.collection_id = c.id)
+		FROM collections c
+		WHERE c.name ILIKE '%%' || $1 || '%%'
+		ORDER BY %s %s, c.id ASC
+		LIMIT $2 OFFSET $3`, "c."+filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var collection Collection
+
+		err := rows.Scan(
+			&totalRecords,
+			&collection.ID,
+			&collection.CreatedAt,
+			&collection.Name,
+			&collection.Description,
+			&collection.Version,
+			&collection.MovieCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return collections, metadata, nil
+}
+
+// Update saves changes to a collection's name and description, provided it is still at
+// collection.Version. If movieIDs is non-nil the collection's movies are replaced with
+// them, in order; otherwise they are left alone.
+func (m CollectionModel) Update(collection *Collection, movieIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE collections
+		SET name = $1, description = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []any{collection.Name, collection.Description, collection.ID, collection.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if movieIDs != nil {
+		err = setCollectionMovies(ctx, tx, collection, movieIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, "DELETE FROM collections WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForMovie returns the collections the movie belongs to, each with the movies either
+// side of it.
+func (m CollectionModel) GetForMovie(movieID int64) ([]*MovieCollection, error) {
+	query := `
+		SELECT c.id, c.name, e.position, e.size, p.id, p.title, p.year, n.id, n.title, n.year
+		FROM (
+			SELECT collection_id, movie_id, position,
+				count(*) OVER (PARTITION BY collection_id) AS size,
+				lag(movie_id) OVER w AS previous_id,
+				lead(movie_id) OVER w AS next_id
+			FROM collection_movies
+			WHERE collection_id IN (SELECT collection_id FROM collection_movies WHERE movie_id = $1)
+			WINDOW w AS (PARTITION BY collection_id ORDER BY position)
+		) e
+		INNER JOIN collections c ON c.id = e.collection_id
+		LEFT JOIN movies p ON p.id = e.previous_id
+		LEFT JOIN movies n ON n.id = e.next_id
+		WHERE e.movie_id = $1
+		ORDER BY c.name, c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []*MovieCollection{}
+
+	for rows.Next() {
+		var mc MovieCollection
+		var previous, next nullMovieSummary
+
+		err := rows.Scan(
+			&mc.ID,
+			&mc.Name,
+			&mc.Position,
+			&mc.MovieCount,
+			&previous.ID,
+			&previous.Title,
+			&previous.Year,
+			&next.ID,
+			&next.Title,
+			&next.Year,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		mc.Previous, mc.Next = previous.summary(), next.summary()
+		collections = append(collections, &mc)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// setCollectionMovies replaces the collection's movies with movieIDs, numbering them
+// from 1 in the order given, and fills in collection.Movies.
+func setCollectionMovies(ctx context.Context, tx *sql.Tx, collection *Collection, movieIDs []int64) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM collection_movies WHERE collection_id = $1", collection.ID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		WITH inserted AS (
+			INSERT INTO collection_movies (collection_id, movie_id, position)
+			SELECT $1, ids.movie_id, ids.position
+			FROM unnest($2::bigint[]) WITH ORDINALITY AS ids(movie_id, position)
+			RETURNING movie_id, position
+		)
+		SELECT i.position, m.id, m.title, m.year
+		FROM inserted i
+		INNER JOIN movies m ON m.id = i.movie_id
+		ORDER BY i.position`
+
+	rows, err := tx.QueryContext(ctx, query, collection.ID, pq.Array(movieIDs))
+	if err != nil {
+		return movieReferenceError(err)
+	}
+
+	collection.Movies, err = scanCollectionEntries(rows)
+	if err != nil {
+		return movieReferenceError(err)
+	}
+	collection.MovieCount = len(collection.Movies)
+
+	return nil
+}
+
+// scanCollectionEntries reads and closes rows of position, id, title and year.
+func scanCollectionEntries(rows *sql.Rows) ([]CollectionEntry, error) {
+	defer rows.Close()
+
+	entries := []CollectionEntry{}
+
+	for rows.Next() {
+		var entry CollectionEntry
+
+		err := rows.Scan(&entry.Position, &entry.ID, &entry.Title, &entry.Year)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// movieReferenceError maps a foreign key violation on a reference to the movies table
+// onto ErrUnknownMovie.
+func movieReferenceError(err error) error {
+	var pqErr *pq.Error
+
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		return ErrUnknownMovie
+	}
+	return err
+}
+
+// nullMovieSummary scans a movie summary from a LEFT JOIN, where every column may be null.
+type nullMovieSummary struct {
+	ID    sql.NullInt64
+	Title sql.NullString
+	Year  sql.NullInt32
+}
+
+func (s nullMovieSummary) summary() *MovieSummary {
+	if !s.ID.Valid {
+		return nil
+	}
+	return &MovieSummary{ID: s.ID.Int64, Title: s.Title.String, Year: s.Year.Int32}
+}
@@ -1,7 +1,12 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"slices"
 	"strings"
 
 	"greenlight.natenine.com/internal/validator"
@@ -12,6 +17,16 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+
+	// After and Before hold opaque cursors taken from a previous page's metadata. When
+	// one is set the results are paged by keyset (WHERE sort_key > cursor) rather than by
+	// OFFSET, which stays fast however deep into the results the client goes.
+	After  string
+	Before string
+
+	// IncludeTotal controls whether the total number of matching records is counted,
+	// which means scanning every match.
+	IncludeTotal bool
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -21,6 +36,23 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be less than 100")
 
 	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	v.Check(f.After == "" || f.Before == "", "after", "must not be used together with before")
+
+	for key, value := range map[string]string{"after": f.After, "before": f.Before} {
+		if value == "" {
+			continue
+		}
+
+		v.Check(f.Page == 1, "page", "must not be used together with a cursor")
+
+		c, err := decodeCursor(value)
+		if err != nil {
+			v.AddError(key, "invalid cursor")
+			continue
+		}
+		v.Check(c.Sort == f.Sort, key, "cursor does not match the sort parameter")
+	}
 }
 
 // Check that the client-provided Sort field matches one of the entries in our safelist
@@ -37,10 +69,20 @@ func (f Filters) sortColumn() string {
 	panic("unsafe sort parameter: " + f.Sort)
 }
 
+// descendingColumns are scores where a higher value is better, so that sorting by them
+// lists the highest values first and a leading hyphen reverses this.
+var descendingColumns = map[string]bool{"relevance": true, "popularity": true}
+
 // Return the sort direction ("ASC" or "DESC") depending on the prefix character of the
 // Sort field.
 func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+	descending := strings.HasPrefix(f.Sort, "-")
+
+	if descendingColumns[strings.TrimPrefix(f.Sort, "-")] {
+		descending = !descending
+	}
+
+	if descending {
 		return "DESC"
 	}
 	return "ASC"
@@ -51,15 +93,110 @@ func (f Filters) limit() int {
 }
 
 func (f Filters) offset() int {
+	if f.After != "" || f.Before != "" {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
 
+// orderBy returns the ORDER BY clause for a keyset-paginated query, with the record id
+// as a tie-breaker. When paging backwards the order is reversed, so that the rows
+// nearest the cursor come first; paginate() puts them back in order afterwards.
+func (f Filters) orderBy(column string) string {
+	direction, idDirection := f.sortDirection(), "ASC"
+
+	if f.Before != "" {
+		direction, idDirection = reverseDirection(direction), reverseDirection(idDirection)
+	}
+
+	return fmt.Sprintf("%s %s, id %s", column, direction, idDirection)
+}
+
+// keyset returns a SQL condition restricting the results to rows after (or before) the
+// cursor, ordered by column and then id. Its two parameters are numbered from n and
+// their values are returned in args. If no cursor is set it returns "TRUE".
+func (f Filters) keyset(column string, n int) (condition string, args []any) {
+	value := f.After
+	if value == "" {
+		value = f.Before
+	}
+	if value == "" {
+		return "TRUE", nil
+	}
+
+	// The cursor has already been checked by ValidateFilters.
+	c, _ := decodeCursor(value)
+
+	op, idOp := ">", ">"
+	if f.sortDirection() == "DESC" {
+		op = "<"
+	}
+	if f.Before != "" {
+		op, idOp = reverseOp(op), reverseOp(idOp)
+	}
+
+	condition = fmt.Sprintf("(%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND id %[4]s $%[5]d))", column, op, n, idOp, n+1)
+
+	return condition, []any{c.Value, c.ID}
+}
+
+func reverseDirection(direction string) string {
+	if direction == "ASC" {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+func reverseOp(op string) string {
+	if op == ">" {
+		return "<"
+	}
+	return ">"
+}
+
+// cursor is the decoded form of the After and Before values. It holds the sort key and
+// id of the record at the edge of a page, along with the sort it was generated for.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return c, err
+	}
+
+	if c.ID < 1 {
+		return c, errors.New("invalid cursor")
+	}
+
+	return c, nil
+}
+
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+	Next         string `json:"next,omitempty"`
+	Prev         string `json:"prev,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pagesize int) Metadata {
@@ -75,3 +212,59 @@ func calculateMetadata(totalRecords, page, pagesize int) Metadata {
 		TotalRecords: totalRecords,
 	}
 }
+
+// paginate trims a page of results which was fetched with one extra row (to find out
+// whether there are more), restores the order of a backwards page, and works out the
+// metadata including the cursors for the neighbouring pages. The key function returns
+// the sort value and id for a record. A negative totalRecords means it wasn't counted.
+func paginate[T any](items []T, f Filters, totalRecords int, key func(T) (string, int64)) ([]T, Metadata) {
+	more := len(items) > f.limit()
+	if more {
+		items = items[:f.limit()]
+	}
+
+	if f.Before != "" {
+		slices.Reverse(items)
+	}
+
+	var metadata Metadata
+
+	switch {
+	case totalRecords < 0:
+		if len(items) > 0 {
+			metadata.PageSize = f.PageSize
+		}
+	case f.After != "" || f.Before != "":
+		if totalRecords > 0 {
+			metadata.PageSize = f.PageSize
+			metadata.TotalRecords = totalRecords
+		}
+	default:
+		metadata = calculateMetadata(totalRecords, f.Page, f.PageSize)
+	}
+
+	if f.After == "" && f.Before == "" && len(items) > 0 {
+		metadata.CurrentPage = f.Page
+	}
+
+	hasNext, hasPrev := more, f.Page > 1
+	switch {
+	case f.After != "":
+		hasPrev = true
+	case f.Before != "":
+		hasNext, hasPrev = true, more
+	}
+
+	if len(items) > 0 {
+		if hasNext {
+			value, id := key(items[len(items)-1])
+			metadata.NextCursor = encodeCursor(cursor{Sort: f.Sort, Value: value, ID: id})
+		}
+		if hasPrev {
+			value, id := key(items[0])
+			metadata.PrevCursor = encodeCursor(cursor{Sort: f.Sort, Value: value, ID: id})
+		}
+	}
+
+	return items, metadata
+}
@@ -0,0 +1,436 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.natenine.com/internal/validator"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrDuplicateAlias = errors.New("duplicate genre alias")
+	ErrGenreInUse     = errors.New("genre in use")
+)
+
+var (
+	slugRX    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	nonSlugRX = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// GenreSlug turns a genre name into slug form, so that "Sci-Fi", "sci fi" and "SCI_FI" all
+// become "sci-fi". Genre slugs and aliases are stored in this form, and names given by
+// clients are converted before they're looked up.
+func GenreSlug(name string) string {
+	return strings.Trim(nonSlugRX.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// Genre is an entry in the managed genre list. Movies refer to genres by Slug, which
+// never changes. Aliases are other names which are accepted for the genre and mapped onto
+// its slug, and Parent is the slug of a broader genre, if any.
+type Genre struct {
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Parent    string    `json:"parent,omitempty"`
+	Aliases   []string  `json:"aliases"`
+	CreatedAt time.Time `json:"-"`
+	Version   int32     `json:"version"`
+}
+
+// ValidateGenre checks a genre on its own, and against the rest of the taxonomy: its
+// parent must exist without making a cycle, and its aliases mustn't clash with the names
+// of other genres.
+func ValidateGenre(v *validator.Validator, genre *Genre, taxonomy *GenreTaxonomy) {
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(slugRX.MatchString(genre.Slug), "slug", "must contain only lowercase letters, digits and single hyphens")
+	v.Check(len(genre.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(genre.Aliases) <= 20, "aliases", "must not contain more than 20 aliases")
+	v.Check(validator.Unique(genre.Aliases), "aliases", "must not contain duplicate values")
+
+	for _, alias := range genre.Aliases {
+		v.Check(alias != "", "aliases", "must not contain empty values")
+		v.Check(alias != genre.Slug, "aliases", "must not contain the genre's own slug")
+
+		if slug, ok := taxonomy.Canonical(alias); ok && slug != genre.Slug {
+			v.AddError("aliases", "must not contain names of other genres")
+		}
+	}
+
+	if genre.Parent != "" {
+		v.Check(genre.Parent != genre.Slug, "parent", "must not be the genre itself")
+		v.Check(taxonomy.exists(genre.Parent), "parent", "must be an existing genre")
+		v.Check(!slices.Contains(taxonomy.Ancestors(genre.Parent), genre.Slug), "parent", "must not be a descendant of the genre")
+	}
+}
+
+// GenreTaxonomy is a snapshot of the genre list, used to map the names of genres given
+// by clients onto their slugs.
+type GenreTaxonomy struct {
+	canonical map[string]string
+	parents   map[string]string
+}
+
+// Canonical returns the slug for a genre name, which may be the slug itself or one of
+// the genre's aliases in any case or spacing. It returns false for unknown genres.
+func (t *GenreTaxonomy) Canonical(name string) (string, bool) {
+	slug, ok := t.canonical[GenreSlug(name)]
+	return slug, ok
+}
+
+// Ancestors returns the parent of a genre, its parent's parent and so on.
+func (t *GenreTaxonomy) Ancestors(slug string) []string {
+	var ancestors []string
+
+	for parent := t.parents[slug]; parent != "" && !slices.Contains(ancestors, parent); parent = t.parents[parent] {
+		ancestors = append(ancestors, parent)
+	}
+
+	return ancestors
+}
+
+func (t *GenreTaxonomy) exists(slug string) bool {
+	_, ok := t.parents[slug]
+	return ok
+}
+
+// taxonomyCacheTTL bounds how long a cached taxonomy is used for. Changes made on this
+// instance clear the cache straight away, but this is how long it takes for changes
+// made on other instances to be seen.
+const taxonomyCacheTTL = time.Minute
+
+// TaxonomyCache holds the most recently loaded genre taxonomy, so that validating movies
+// doesn't query the genre tables every time. A nil *TaxonomyCache is valid and caches
+// nothing.
+type TaxonomyCache struct {
+	mu       sync.Mutex
+	taxonomy *GenreTaxonomy
+	loadedAt time.Time
+}
+
+func NewTaxonomyCache() *TaxonomyCache {
+	return &TaxonomyCache{}
+}
+
+// get returns the cached taxonomy, calling load to refresh it if it has expired or been
+// cleared. The lock is held while loading, so that clear waits for a load in progress
+// rather than the load putting back a snapshot from before the change.
+func (c *TaxonomyCache) get(load func() (*GenreTaxonomy, error)) (*GenreTaxonomy, error) {
+	if c == nil {
+		return load()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.taxonomy != nil && time.Since(c.loadedAt) < taxonomyCacheTTL {
+		return c.taxonomy, nil
+	}
+
+	taxonomy, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.taxonomy = taxonomy
+	c.loadedAt = time.Now()
+
+	return taxonomy, nil
+}
+
+// clear drops the cached taxonomy, after the genre list has changed.
+func (c *TaxonomyCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.taxonomy = nil
+	c.mu.Unlock()
+}
+
+type GenreModel struct {
+	DB    *sql.DB
+	Cache *TaxonomyCache
+}
+
+// Taxonomy returns a snapshot of the genre list for validating movies. The snapshot is
+// shared between callers and must not be changed.
+func (m GenreModel) Taxonomy() (*GenreTaxonomy, error) {
+	return m.Cache.get(m.loadTaxonomy)
+}
+
+func (m GenreModel) loadTaxonomy() (*GenreTaxonomy, error) {
+	query := `
+		SELECT g.slug, coalesce(g.parent, ''), coalesce(array_agg(a.alias) FILTER (WHERE a.alias IS NOT NULL), '{}')
+		FROM genres g
+		LEFT JOIN genre_aliases a ON a.genre = g.slug
+		GROUP BY g.slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taxonomy := &GenreTaxonomy{
+		canonical: make(map[string]string),
+		parents:   make(map[string]string),
+	}
+
+	for rows.Next() {
+		var slug, parent string
+		var aliases []string
+
+		err := rows.Scan(&slug, &parent, pq.Array(&aliases))
+		if err != nil {
+			return nil, err
+		}
+
+		taxonomy.canonical[slug] = slug
+		taxonomy.parents[slug] = parent
+		for _, alias := range aliases {
+			taxonomy.canonical[alias] = slug
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return taxonomy, nil
+}
+
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT g.slug, g.name, coalesce(g.parent, ''), coalesce(array_agg(a.alias ORDER BY a.alias) FILTER (WHERE a.alias IS NOT NULL), '{}'), g.created_at, g.version
+		FROM genres g
+		LEFT JOIN genre_aliases a ON a.genre = g.slug
+		GROUP BY g.slug
+		ORDER BY g.slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		genre, err := scanGenre(rows)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+func (m GenreModel) Get(slug string) (*Genre, error) {
+	query := `
+		SELECT g.slug, g.name, coalesce(g.parent, ''), coalesce(array_agg(a.alias ORDER BY a.alias) FILTER (WHERE a.alias IS NOT NULL), '{}'), g.created_at, g.version
+		FROM genres g
+		LEFT JOIN genre_aliases a ON a.genre = g.slug
+		WHERE g.slug = $1
+		GROUP BY g.slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	genre, err := scanGenre(m.DB.QueryRowContext(ctx, query, slug))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return genre, nil
+}
+
+func (m GenreModel) Insert(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO genres (slug, name, parent)
+		VALUES ($1, $2, nullif($3, ''))
+		RETURNING created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, genre.Slug, genre.Name, genre.Parent).Scan(&genre.CreatedAt, &genre.Version)
+	if err != nil {
+		return genreError(err)
+	}
+
+	err = setGenreAliases(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.Cache.clear()
+
+	return nil
+}
+
+// Update saves changes to a genre's name, parent and aliases. The slug can't be changed,
+// since movies refer to the genre by it.
+func (m GenreModel) Update(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE genres
+		SET name = $1, parent = nullif($2, ''), version = version + 1
+		WHERE slug = $3 AND version = $4
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, genre.Name, genre.Parent, genre.Slug, genre.Version).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return genreError(err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM genre_aliases WHERE genre = $1", genre.Slug)
+	if err != nil {
+		return err
+	}
+
+	err = setGenreAliases(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.Cache.clear()
+
+	return nil
+}
+
+// Delete removes a genre, as long as no movie uses it. Any child genres are left without
+// a parent.
+func (m GenreModel) Delete(slug string) error {
+	query := `
+		DELETE FROM genres
+		WHERE slug = $1 AND NOT EXISTS (SELECT 1 FROM movies WHERE genres @> ARRAY[$1])
+		RETURNING slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&slug)
+	if err == nil {
+		m.Cache.clear()
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// Nothing was deleted, either because the genre doesn't exist or because it's in use.
+	var exists bool
+
+	err = m.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM genres WHERE slug = $1)", slug).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrGenreInUse
+	}
+	return ErrRecordNotFound
+}
+
+func setGenreAliases(ctx context.Context, tx *sql.Tx, genre *Genre) error {
+	if len(genre.Aliases) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO genre_aliases (alias, genre)
+		SELECT unnest($1::text[]), $2`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(genre.Aliases), genre.Slug)
+	return genreError(err)
+}
+
+// genreError maps constraint violations from the genre tables onto errors the handlers
+// can report to the client.
+func genreError(err error) error {
+	var pqErr *pq.Error
+
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		switch pqErr.Constraint {
+		case "genres_pkey":
+			return ErrDuplicateGenre
+		case "genre_aliases_pkey":
+			return ErrDuplicateAlias
+		}
+	}
+	return err
+}
+
+func scanGenre(row interface{ Scan(...any) error }) (*Genre, error) {
+	var genre Genre
+
+	err := row.Scan(
+		&genre.Slug,
+		&genre.Name,
+		&genre.Parent,
+		pq.Array(&genre.Aliases),
+		&genre.CreatedAt,
+		&genre.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &genre, nil
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// IdempotencyLockTimeout is how long a request holding an idempotency key can go without
+// completing before the key is treated as abandoned, such as after a crash, and can be
+// claimed by a retry.
+const IdempotencyLockTimeout = time.Minute
+
+// IdempotencyKey is a key sent by a client in an Idempotency-Key header, along with the
+// response to the first request sent with it. Keys are scoped to the user and endpoint.
+type IdempotencyKey struct {
+	UserID   int64
+	Endpoint string
+	Key      string
+
+	// Fingerprint is a hash of the request, used to spot the key being reused for a
+	// different request.
+	Fingerprint []byte
+
+	// CreatedAt is when the key was claimed. It is used to check that a key is still held
+	// by the same request when it completes.
+	CreatedAt time.Time
+
+	// The response is only set once Completed is true.
+	Completed bool
+	Status    int
+	Header    map[string][]string
+	Body      []byte
+}
+
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+// Claim records that a request with the key is being processed. If the key was free it
+// is claimed and nil is returned. Otherwise the stored key is returned, and the caller
+// should compare its fingerprint and replay its response if it has completed.
+//
+// The primary key makes concurrent claims safe: a second insert of the same key waits on
+// the row lock held by the first, and then finds the key taken. Keys which completed
+// more than ttl ago, or were abandoned, are claimed afresh.
+func (m IdempotencyKeyModel) Claim(key *IdempotencyKey, ttl time.Duration) (*IdempotencyKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	claim := `
+		INSERT INTO idempotency_keys (user_id, endpoint, key, fingerprint)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, endpoint, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, created_at = NOW(), completed_at = NULL,
+			status = NULL, headers = NULL, body = NULL
+		WHERE idempotency_keys.created_at < $5
+			OR (idempotency_keys.completed_at IS NULL AND idempotency_keys.created_at < $6)
+		RETURNING created_at`
+
+	lookup := `
+		SELECT fingerprint, created_at, completed_at IS NOT NULL,
+			coalesce(status, 0), coalesce(headers, '{}'), coalesce(body, '')
+		FROM idempotency_keys
+		WHERE user_id = $1 AND endpoint = $2 AND key = $3`
+
+	now := time.Now()
+
+	// The stored key can expire and be deleted between the two queries, in which case
+	// the claim is tried again.
+	for attempt := 0; attempt < 3; attempt++ {
+		err := m.DB.QueryRowContext(ctx, claim, key.UserID, key.Endpoint, key.Key, key.Fingerprint, now.Add(-ttl), now.Add(-IdempotencyLockTimeout)).Scan(&key.CreatedAt)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		stored := IdempotencyKey{UserID: key.UserID, Endpoint: key.Endpoint, Key: key.Key}
+		var header []byte
+
+		err = m.DB.QueryRowContext(ctx, lookup, key.UserID, key.Endpoint, key.Key).Scan(
+			&stored.Fingerprint,
+			&stored.CreatedAt,
+			&stored.Completed,
+			&stored.Status,
+			&header,
+			&stored.Body,
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(header, &stored.Header)
+		if err != nil {
+			return nil, err
+		}
+
+		return &stored, nil
+	}
+
+	return nil, errors.New("unable to claim idempotency key")
+}
+
+// Complete stores the response to the request which claimed the key. If the key has
+// since been claimed by another request, nothing is stored.
+func (m IdempotencyKeyModel) Complete(key *IdempotencyKey, status int, header map[string][]string, body []byte) error {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET completed_at = NOW(), status = $1, headers = $2, body = $3
+		WHERE user_id = $4 AND endpoint = $5 AND key = $6 AND created_at = $7 AND completed_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, status, headerJSON, body, key.UserID, key.Endpoint, key.Key, key.CreatedAt)
+	return err
+}
+
+// Release frees a key claimed by a request which failed, so that it can be retried.
+func (m IdempotencyKeyModel) Release(key *IdempotencyKey) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND endpoint = $2 AND key = $3 AND created_at = $4 AND completed_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key.UserID, key.Endpoint, key.Key, key.CreatedAt)
+	return err
+}
+
+// DeleteExpired deletes keys which were claimed more than ttl ago.
+func (m IdempotencyKeyModel) DeleteExpired(ttl time.Duration) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now().Add(-ttl))
+	return err
+}
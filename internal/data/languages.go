@@ -0,0 +1,106 @@
+package data
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CanonicalLanguageTag checks that tag is a well-formed BCP 47 language tag of the form
+// language[-script][-region][-variant...], and returns it in canonical case, such as
+// "zh-Hant-TW" for "ZH_hant_tw". Extensions and private use tags aren't supported.
+func CanonicalLanguageTag(tag string) (string, bool) {
+	parts := strings.Split(strings.ReplaceAll(tag, "_", "-"), "-")
+
+	for _, part := range parts {
+		if part == "" {
+			return "", false
+		}
+		for _, r := range part {
+			if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return "", false
+			}
+		}
+	}
+
+	if !isLetters(parts[0]) || len(parts[0]) < 2 || len(parts[0]) > 3 {
+		return "", false
+	}
+	canonical := []string{strings.ToLower(parts[0])}
+	rest := parts[1:]
+
+	if len(rest) > 0 && len(rest[0]) == 4 && isLetters(rest[0]) {
+		canonical = append(canonical, strings.ToUpper(rest[0][:1])+strings.ToLower(rest[0][1:]))
+		rest = rest[1:]
+	}
+
+	if len(rest) > 0 && ((len(rest[0]) == 2 && isLetters(rest[0])) || (len(rest[0]) == 3 && isDigits(rest[0]))) {
+		canonical = append(canonical, strings.ToUpper(rest[0]))
+		rest = rest[1:]
+	}
+
+	for _, variant := range rest {
+		if !(len(variant) >= 5 && len(variant) <= 8) && !(len(variant) == 4 && unicode.IsDigit(rune(variant[0]))) {
+			return "", false
+		}
+		canonical = append(canonical, strings.ToLower(variant))
+	}
+
+	return strings.Join(canonical, "-"), true
+}
+
+// BaseLanguage returns the language subtag of a canonical tag, such as "pt" for "pt-BR".
+func BaseLanguage(tag string) string {
+	base, _, _ := strings.Cut(tag, "-")
+	return base
+}
+
+// searchConfigs are the Postgres text search configurations for the languages which have
+// one. Other languages are searched with the "simple" configuration, which doesn't stem.
+var searchConfigs = map[string]string{
+	"ar": "arabic",
+	"da": "danish",
+	"de": "german",
+	"el": "greek",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"hu": "hungarian",
+	"id": "indonesian",
+	"it": "italian",
+	"nl": "dutch",
+	"no": "norwegian",
+	"nb": "norwegian",
+	"nn": "norwegian",
+	"pt": "portuguese",
+	"ro": "romanian",
+	"ru": "russian",
+	"sv": "swedish",
+	"tr": "turkish",
+}
+
+// SearchConfig returns the text search configuration to use for a language tag.
+func SearchConfig(tag string) string {
+	if config, ok := searchConfigs[BaseLanguage(tag)]; ok {
+		return config
+	}
+	return "simple"
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -11,17 +13,79 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Users       UserModel
-	Tokens      TokenModel
+	Movies            MovieModel
+	MovieVersions     MovieVersionModel
+	MovieEvents       MovieEventModel
+	MovieImports      MovieImportModel
+	MovieImages       MovieImageModel
+	ExternalIDs       ExternalIDModel
+	Collections       CollectionModel
+	Relations         MovieRelationModel
+	Popularity        PopularityModel
+	Translations      MovieTranslationModel
+	Releases          MovieReleaseModel
+	Ratings           RatingModel
+	Watchlist         WatchlistModel
+	WatchHistory      WatchHistoryModel
+	Recommendations   RecommendationModel
+	Genres            GenreModel
+	Permissions       PermissionModel
+	Users             UserModel
+	Tokens            TokenModel
+	IdempotencyKeys   IdempotencyKeyModel
+	Webhooks          WebhookModel
+	WebhookDeliveries WebhookDeliveryModel
 }
 
-func NewModels(db *sql.DB) Models {
+// CacheTTLs sets how long the models cache results for. A TTL of zero disables that
+// cache.
+type CacheTTLs struct {
+	Similar time.Duration
+	Stats   time.Duration
+}
+
+func NewModels(db *sql.DB, ttls CacheTTLs) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
+		Movies:            MovieModel{DB: db, Similar: NewSimilarCache(ttls.Similar), StatsCache: NewStatsCache(ttls.Stats)},
+		MovieVersions:     MovieVersionModel{DB: db},
+		MovieEvents:       MovieEventModel{DB: db},
+		MovieImports:      MovieImportModel{DB: db},
+		MovieImages:       MovieImageModel{DB: db},
+		ExternalIDs:       ExternalIDModel{DB: db},
+		Collections:       CollectionModel{DB: db},
+		Relations:         MovieRelationModel{DB: db},
+		Popularity:        PopularityModel{DB: db},
+		Translations:      MovieTranslationModel{DB: db},
+		Releases:          MovieReleaseModel{DB: db},
+		Ratings:           RatingModel{DB: db},
+		Watchlist:         WatchlistModel{DB: db},
+		WatchHistory:      WatchHistoryModel{DB: db},
+		Recommendations:   RecommendationModel{DB: db},
+		Genres:            GenreModel{DB: db, Cache: NewTaxonomyCache()},
+		Permissions:       PermissionModel{DB: db},
+		Users:             UserModel{DB: db},
+		Tokens:            TokenModel{DB: db},
+		IdempotencyKeys:   IdempotencyKeyModel{DB: db},
+		Webhooks:          WebhookModel{DB: db},
+		WebhookDeliveries: WebhookDeliveryModel{DB: db},
+	}
+}
+
+// withSavepoint runs fn inside a savepoint on tx. If fn fails, the transaction is rolled
+// back to the savepoint, leaving it usable. fn's error is not returned: callers which
+// need it keep it themselves. The error returned is only set if the savepoint itself
+// fails, in which case the transaction should be abandoned.
+func withSavepoint(ctx context.Context, tx *sql.Tx, fn func() error) error {
+	_, err := tx.ExecContext(ctx, "SAVEPOINT row_savepoint")
+	if err != nil {
+		return err
+	}
+
+	if fn() != nil {
+		_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT row_savepoint")
+		return err
 	}
+
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT row_savepoint")
+	return err
 }
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieBatch runs a series of movie changes inside a single database transaction. Nothing
+// is saved until Commit is called, and Rollback discards every change made so far.
+type MovieBatch struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	tx     *sql.Tx
+	userID int64
+
+	// similar is invalidated for every movie changed by the batch once it's committed.
+	similar *SimilarCache
+	changed []int64
+}
+
+// NewBatch starts a new batch on behalf of the given user, who is recorded as the author
+// of every change in the movie revision history.
+func (m MovieModel) NewBatch(userID int64) (*MovieBatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &MovieBatch{ctx: ctx, cancel: cancel, tx: tx, userID: userID, similar: m.Similar}, nil
+}
+
+func (b *MovieBatch) Get(id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, version
+		FROM movies
+		WHERE id=$1`
+
+	var movie Movie
+
+	err := b.tx.QueryRowContext(b.ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+func (b *MovieBatch) Insert(movie *Movie) error {
+	return insertMovie(b.ctx, b.tx, movie, b.userID)
+}
+
+func (b *MovieBatch) Update(movie *Movie) error {
+	err := updateMovie(b.ctx, b.tx, movie, b.userID)
+	if err != nil {
+		return err
+	}
+
+	b.changed = append(b.changed, movie.ID)
+	return nil
+}
+
+// Delete removes a movie, checking that it is still at version unless version is zero.
+func (b *MovieBatch) Delete(id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	err := deleteMovie(b.ctx, b.tx, id, version)
+	if err != nil {
+		return err
+	}
+
+	b.changed = append(b.changed, id)
+	return nil
+}
+
+// Do runs fn in a savepoint. If fn returns an error, only the changes made by fn are
+// undone and the rest of the batch carries on; fn's error is left for the caller to
+// keep. The error returned is only set if the savepoint itself couldn't be managed, in
+// which case the batch should be rolled back.
+func (b *MovieBatch) Do(fn func() error) error {
+	return withSavepoint(b.ctx, b.tx, fn)
+}
+
+func (b *MovieBatch) Commit() error {
+	defer b.cancel()
+
+	err := b.tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	b.similar.Invalidate(b.changed...)
+	return nil
+}
+
+// Rollback discards the batch. It is safe to call after Commit, so it can be deferred.
+func (b *MovieBatch) Rollback() error {
+	defer b.cancel()
+
+	err := b.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieEventsChannel is the Postgres notification channel which is notified each time a
+// movie event is recorded, once the transaction recording it commits.
+const MovieEventsChannel = "movie_events"
+
+// The kinds of movie event.
+const (
+	MovieCreated = "created"
+	MovieUpdated = "updated"
+	MovieDeleted = "deleted"
+)
+
+// movieEventsPositionLock is the advisory lock key held while giving committed events
+// their positions in the log, so that only one instance does it at a time. Writers never
+// take it.
+const movieEventsPositionLock = 0x6d6f7669 // "movi"
+
+// MovieEvent records a movie being created, updated or deleted, along with the version
+// of the movie it left behind (or, for a deletion, the version which was deleted). Its
+// ID is its position in the log.
+type MovieEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Kind      string    `json:"kind"`
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+}
+
+// recordMovieEvent adds an event to the log and notifies listeners once tx commits. The
+// event has no position until Position is next run after the commit.
+func recordMovieEvent(ctx context.Context, tx *sql.Tx, kind string, movieID int64, version int32) error {
+	query := `
+		INSERT INTO movie_events (kind, movie_id, version)
+		VALUES ($1, $2, $3)`
+
+	_, err := tx.ExecContext(ctx, query, kind, movieID, version)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "SELECT pg_notify($1, '')", MovieEventsChannel)
+	return err
+}
+
+// recordMovieEvents records an event of the same kind for each of movies, as
+// recordMovieEvent does for one, with a single statement.
+func recordMovieEvents(ctx context.Context, tx *sql.Tx, kind string, movies []*Movie) error {
+	ids := make([]int64, len(movies))
+	versions := make([]int32, len(movies))
+
+	for i, movie := range movies {
+		ids[i] = movie.ID
+		versions[i] = movie.Version
+	}
+
+	query := `
+		INSERT INTO movie_events (kind, movie_id, version)
+		SELECT $1, e.movie_id, e.version
+		FROM unnest($2::bigint[], $3::integer[]) WITH ORDINALITY AS e(movie_id, version, n)
+		ORDER BY e.n`
+
+	_, err := tx.ExecContext(ctx, query, kind, pq.Array(ids), pq.Array(versions))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "SELECT pg_notify($1, '')", MovieEventsChannel)
+	return err
+}
+
+type MovieEventModel struct {
+	DB *sql.DB
+}
+
+// Position gives each committed event without a position the next one in the log. As
+// positions are only handed out after the events have committed, every event given a
+// position later gets a higher one.
+func (m MovieEventModel) Position() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", movieEventsPositionLock)
+	if err != nil {
+		return err
+	}
+
+	// The statement's snapshot is taken after the lock is held, so it sees the positions
+	// handed out by whichever instance held it last.
+	query := `
+		UPDATE movie_events e
+		SET position = p.position
+		FROM (
+			SELECT id, (SELECT coalesce(max(position), 0) FROM movie_events) + row_number() OVER (ORDER BY id) AS position
+			FROM movie_events
+			WHERE position IS NULL
+		) p
+		WHERE e.id = p.id`
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAfter returns up to limit events with a position after afterID, oldest first.
+func (m MovieEventModel) GetAfter(afterID int64, limit int) ([]*MovieEvent, error) {
+	query := `
+		SELECT position, created_at, kind, movie_id, version
+		FROM movie_events
+		WHERE position > $1
+		ORDER BY position
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*MovieEvent{}
+
+	for rows.Next() {
+		var event MovieEvent
+
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.Kind, &event.MovieID, &event.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Bounds returns the positions of the oldest and newest events in the log, which are
+// both zero if the log is empty.
+func (m MovieEventModel) Bounds() (oldest, newest int64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, "SELECT coalesce(min(position), 0), coalesce(max(position), 0) FROM movie_events").Scan(&oldest, &newest)
+	return oldest, newest, err
+}
+
+// DeleteExpired deletes events recorded more than retention ago. The newest event is
+// always kept, so that clients resuming from an older one can tell that they have missed
+// events, and so are events which don't have a position yet.
+func (m MovieEventModel) DeleteExpired(retention time.Duration) error {
+	query := `
+		DELETE FROM movie_events
+		WHERE created_at < $1 AND position < (SELECT max(position) FROM movie_events)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	return err
+}
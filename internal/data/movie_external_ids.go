@@ -0,0 +1,269 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// ErrExternalIDConflict is returned when the external ids given for a movie belong to
+// more than one existing movie, which usually means those movies are duplicates.
+var ErrExternalIDConflict = errors.New("external ids belong to different movies")
+
+// externalIDFormats are the upstream catalogues whose ids can be attached to movies, with
+// the format of their ids.
+var externalIDFormats = map[string]*regexp.Regexp{
+	"imdb":       regexp.MustCompile(`^tt\d{7,}$`),
+	"tmdb":       regexp.MustCompile(`^\d+$`),
+	"wikidata":   regexp.MustCompile(`^Q\d+$`),
+	"tvdb":       regexp.MustCompile(`^\d+$`),
+	"letterboxd": regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`),
+}
+
+// ExternalIDSources returns the names of the supported catalogues, sorted.
+func ExternalIDSources() []string {
+	sources := make([]string, 0, len(externalIDFormats))
+	for source := range externalIDFormats {
+		sources = append(sources, source)
+	}
+	slices.Sort(sources)
+	return sources
+}
+
+// ExternalID identifies a movie in an upstream catalogue. Each external id belongs to at
+// most one movie, but a movie can have several from the same source, for example after
+// duplicates have been merged.
+type ExternalID struct {
+	Source string `json:"source"`
+	ID     string `json:"id"`
+}
+
+func ValidateExternalID(v *validator.Validator, key string, id ExternalID) {
+	format, ok := externalIDFormats[id.Source]
+	if !ok {
+		v.AddError(key, fmt.Sprintf("source must be one of %s", strings.Join(ExternalIDSources(), ", ")))
+		return
+	}
+	v.Check(format.MatchString(id.ID), key, fmt.Sprintf("is not a valid %s id", id.Source))
+}
+
+func ValidateExternalIDs(v *validator.Validator, ids []ExternalID) {
+	v.Check(len(ids) <= 20, "external_ids", "must not contain more than 20 ids")
+	v.Check(validator.Unique(ids), "external_ids", "must not contain duplicate values")
+
+	for i, id := range ids {
+		ValidateExternalID(v, fmt.Sprintf("external_ids[%d]", i), id)
+	}
+}
+
+type ExternalIDModel struct {
+	DB *sql.DB
+}
+
+// Lookup returns the id of the movie with the given external id.
+func (m ExternalIDModel) Lookup(id ExternalID) (int64, error) {
+	query := `
+		SELECT movie_id
+		FROM movie_external_ids
+		WHERE source = $1 AND external_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var movieID int64
+
+	err := m.DB.QueryRowContext(ctx, query, id.Source, id.ID).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return movieID, nil
+}
+
+// GetForMovies returns the external ids of each of the given movies, keyed by movie id.
+func (m ExternalIDModel) GetForMovies(movieIDs []int64) (map[int64][]ExternalID, error) {
+	query := `
+		SELECT movie_id, source, external_id
+		FROM movie_external_ids
+		WHERE movie_id = ANY($1)
+		ORDER BY movie_id, source, external_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64][]ExternalID)
+
+	for rows.Next() {
+		var movieID int64
+		var id ExternalID
+
+		err := rows.Scan(&movieID, &id.Source, &id.ID)
+		if err != nil {
+			return nil, err
+		}
+		ids[movieID] = append(ids[movieID], id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Upsert saves a movie from an upstream catalogue. If any of its external ids already
+// belongs to a movie, that movie is updated instead of a new one being created (and left
+// alone if nothing has changed). Either way, the external ids are all attached to the
+// movie afterwards. The returned bool reports whether a new movie was created.
+func (m MovieModel) Upsert(movie *Movie, ids []ExternalID, userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	sources, externalIDs := make([]string, len(ids)), make([]string, len(ids))
+	for i, id := range ids {
+		sources[i], externalIDs[i] = id.Source, id.ID
+	}
+
+	// Two syncs creating the same new movie at once would both find no match. Locking
+	// the external ids makes the second one wait, and then find the first one's movie.
+	query := `
+		SELECT pg_advisory_xact_lock(hashtext(source || ':' || external_id))
+		FROM unnest($1::text[], $2::text[]) AS ids(source, external_id)
+		ORDER BY source, external_id`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(sources), pq.Array(externalIDs))
+	if err != nil {
+		return false, err
+	}
+
+	query = `
+		SELECT DISTINCT e.movie_id
+		FROM movie_external_ids e
+		INNER JOIN unnest($1::text[], $2::text[]) AS ids(source, external_id)
+		ON e.source = ids.source AND e.external_id = ids.external_id`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(sources), pq.Array(externalIDs))
+	if err != nil {
+		return false, err
+	}
+
+	var matches []int64
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return false, err
+		}
+		matches = append(matches, id)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+
+	created := false
+
+	switch len(matches) {
+	case 0:
+		err = insertMovie(ctx, tx, movie, userID)
+		if err != nil {
+			return false, err
+		}
+		created = true
+
+	case 1:
+		err = upsertExistingMovie(ctx, tx, matches[0], movie, userID)
+		if err != nil {
+			return false, err
+		}
+
+	default:
+		return false, ErrExternalIDConflict
+	}
+
+	query = `
+		INSERT INTO movie_external_ids (source, external_id, movie_id)
+		SELECT source, external_id, $3
+		FROM unnest($1::text[], $2::text[]) AS ids(source, external_id)
+		ON CONFLICT (source, external_id) DO NOTHING`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(sources), pq.Array(externalIDs), movie.ID)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	if !created {
+		m.Similar.Invalidate(movie.ID)
+	}
+	return created, nil
+}
+
+// upsertExistingMovie updates the movie with the given id to match movie, filling in
+// movie's id and version. The update is skipped if nothing would change, so that syncing
+// the same data again doesn't add empty versions to the movie's history.
+func upsertExistingMovie(ctx context.Context, tx *sql.Tx, id int64, movie *Movie, userID int64) error {
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, version
+		FROM movies
+		WHERE id = $1
+		FOR UPDATE`
+
+	var existing Movie
+
+	err := tx.QueryRowContext(ctx, query, id).Scan(
+		&existing.ID,
+		&existing.CreatedAt,
+		&existing.Title,
+		&existing.Year,
+		&existing.Runtime,
+		pq.Array(&existing.Genres),
+		&existing.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	movie.ID, movie.CreatedAt, movie.Version = existing.ID, existing.CreatedAt, existing.Version
+
+	changes, err := diffMovies(&existing, movie)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return updateMovie(ctx, tx, movie, userID)
+}
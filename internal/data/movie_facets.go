@@ -0,0 +1,120 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"greenlight.natenine.com/internal/validator"
+)
+
+// FacetSafeList holds the facets which can be requested alongside a movie listing.
+var FacetSafeList = []string{"genres", "decade", "runtime_bucket"}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+func ValidateFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		v.Check(validator.PermittedValue(facet, FacetSafeList...), "facets", "invalid facet value")
+	}
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+}
+
+// Facets counts the movies matching movieFilters under each value of the requested
+// facets. As is usual for faceted navigation, the count for a facet ignores the filters
+// on its own field, so that selecting one genre doesn't hide the counts for the others.
+// Genres are ordered by count, and decades and runtime buckets in ascending order.
+func (m MovieModel) Facets(movieFilters MovieFilters, facets []string) (map[string][]FacetCount, error) {
+	result := make(map[string][]FacetCount)
+
+	if len(facets) == 0 {
+		return result, nil
+	}
+
+	var subqueries []string
+	var args []any
+	var where string
+
+	for _, facet := range facets {
+		f := movieFilters
+
+		switch facet {
+		case "genres":
+			f.Genres, f.GenresAny, f.ExcludeGenres = nil, nil, nil
+			where, args = f.where(args)
+			subqueries = append(subqueries, `
+				SELECT 'genres' AS facet, genre AS value, 0 AS ord, count(*) AS n
+				FROM movies, unnest(genres) AS genre
+				WHERE `+where+`
+				GROUP BY genre`)
+
+		case "decade":
+			f.YearMin, f.YearMax = 0, 0
+			where, args = f.where(args)
+			subqueries = append(subqueries, `
+				SELECT 'decade' AS facet, (year / 10 * 10)::text AS value, year / 10 * 10 AS ord, count(*) AS n
+				FROM movies
+				WHERE `+where+`
+				GROUP BY year / 10 * 10`)
+
+		case "runtime_bucket":
+			f.RuntimeMin, f.RuntimeMax = 0, 0
+			where, args = f.where(args)
+			subqueries = append(subqueries, `
+				SELECT 'runtime_bucket' AS facet, bucket.value, bucket.ord, count(*) AS n
+				FROM movies, LATERAL (
+					SELECT CASE
+						WHEN runtime < 90 THEN '0-89'
+						WHEN runtime < 120 THEN '90-119'
+						WHEN runtime < 150 THEN '120-149'
+						ELSE '150+'
+					END AS value,
+					CASE
+						WHEN runtime < 90 THEN 0
+						WHEN runtime < 120 THEN 1
+						WHEN runtime < 150 THEN 2
+						ELSE 3
+					END AS ord
+				) AS bucket
+				WHERE `+where+`
+				GROUP BY bucket.value, bucket.ord`)
+		}
+	}
+
+	query := strings.Join(subqueries, "\nUNION ALL") + "\nORDER BY facet, ord, n DESC, value"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for _, facet := range facets {
+		result[facet] = []FacetCount{}
+	}
+
+	for rows.Next() {
+		var facet string
+		var ord int
+		var fc FacetCount
+
+		err := rows.Scan(&facet, &fc.Value, &ord, &fc.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		result[facet] = append(result[facet], fc)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// MovieFilters holds the conditions used to select movies for listings and exports. Zero
+// values mean that a filter is not in use.
+type MovieFilters struct {
+	// Title is a web search style query: words are ANDed together, and quoted phrases,
+	// OR and -excluded words are supported.
+	Title string
+
+	// Language is a BCP 47 tag. When it is set, titles are also searched in the movies'
+	// translations into the same base language, using that language's text search
+	// configuration.
+	Language string
+
+	// Genres must all be present on a movie, at least one of GenresAny must be, and
+	// none of ExcludeGenres may be.
+	Genres        []string
+	GenresAny     []string
+	ExcludeGenres []string
+
+	YearMin    int32
+	YearMax    int32
+	RuntimeMin int32
+	RuntimeMax int32
+
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// ValidateMovieFilters checks the filters before they are used. The genre filters are
+// looked up in taxonomy in the same way as a movie's genres: aliases are replaced with
+// the canonical genre slugs, and unknown genres are rejected.
+func ValidateMovieFilters(v *validator.Validator, f *MovieFilters, taxonomy *GenreTaxonomy) {
+	for key, genres := range map[string][]string{"genres": f.Genres, "genres_any": f.GenresAny, "exclude_genres": f.ExcludeGenres} {
+		v.Check(len(genres) <= 20, key, "must not contain more than 20 genres")
+
+		for i, name := range genres {
+			slug, ok := taxonomy.Canonical(name)
+			if !ok {
+				v.AddError(key, fmt.Sprintf("contains unknown genre %q", name))
+				continue
+			}
+			genres[i] = slug
+		}
+
+		v.Check(validator.Unique(genres), key, "must not contain duplicate values")
+	}
+
+	maxYear := int32(time.Now().Year())
+
+	if f.YearMin != 0 {
+		v.Check(f.YearMin >= 1888 && f.YearMin <= maxYear, "year_min", "must be between 1888 and the current year")
+	}
+	if f.YearMax != 0 {
+		v.Check(f.YearMax >= 1888 && f.YearMax <= maxYear, "year_max", "must be between 1888 and the current year")
+	}
+	if f.YearMin != 0 && f.YearMax != 0 {
+		v.Check(f.YearMin <= f.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	v.Check(f.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(f.RuntimeMax >= 0, "runtime_max", "must not be negative")
+	if f.RuntimeMin != 0 && f.RuntimeMax != 0 {
+		v.Check(f.RuntimeMin <= f.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
+
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() {
+		v.Check(f.CreatedAfter.Before(f.CreatedBefore), "created_after", "must be earlier than created_before")
+	}
+}
+
+// where returns the SQL conditions for the filters, joined with AND, appending the
+// parameter values to args. Placeholders are numbered on from the values already in
+// args. If no filters are in use it returns "TRUE".
+func (f MovieFilters) where(args []any) (string, []any) {
+	var conditions []string
+
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if f.Title != "" && f.Language != "" {
+		title := arg(f.Title)
+		conditions = append(conditions, `(to_tsvector('english', title) @@ websearch_to_tsquery('english', `+title+`)
+			OR EXISTS (
+				SELECT 1 FROM movie_translations tr
+				WHERE tr.movie_id = movies.id AND split_part(tr.language, '-', 1) = `+arg(BaseLanguage(f.Language))+`
+				AND to_tsvector(tr.config, tr.title) @@ websearch_to_tsquery(tr.config, `+title+`)
+			))`)
+	} else if f.Title != "" {
+		conditions = append(conditions, "to_tsvector('english', title) @@ websearch_to_tsquery('english', "+arg(f.Title)+")")
+	}
+	if len(f.Genres) > 0 {
+		conditions = append(conditions, "genres @> "+arg(pq.Array(f.Genres)))
+	}
+	if len(f.GenresAny) > 0 {
+		conditions = append(conditions, "genres && "+arg(pq.Array(f.GenresAny)))
+	}
+	if len(f.ExcludeGenres) > 0 {
+		conditions = append(conditions, "NOT genres && "+arg(pq.Array(f.ExcludeGenres)))
+	}
+	if f.YearMin != 0 {
+		conditions = append(conditions, "year >= "+arg(f.YearMin))
+	}
+	if f.YearMax != 0 {
+		conditions = append(conditions, "year <= "+arg(f.YearMax))
+	}
+	if f.RuntimeMin != 0 {
+		conditions = append(conditions, "runtime >= "+arg(f.RuntimeMin))
+	}
+	if f.RuntimeMax != 0 {
+		conditions = append(conditions, "runtime <= "+arg(f.RuntimeMax))
+	}
+	if !f.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at > "+arg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(f.CreatedBefore))
+	}
+
+	if len(conditions) == 0 {
+		return "TRUE", args
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.natenine.com/internal/validator"
+)
+
+const (
+	ImageKindPoster   = "poster"
+	ImageKindBackdrop = "backdrop"
+)
+
+// MovieImage is a poster or backdrop uploaded for a movie. The files themselves are kept
+// in storage under Key and ThumbnailKey; URL and ThumbnailURL are signed links to them
+// which are filled in when the image is sent to a client.
+type MovieImage struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	MovieID      int64     `json:"movie_id"`
+	Kind         string    `json:"kind"`
+	ContentType  string    `json:"content_type"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Size         int64     `json:"size"`
+	Key          string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	URL          string    `json:"url,omitempty"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+}
+
+func ValidateMovieImage(v *validator.Validator, image *MovieImage) {
+	v.Check(validator.PermittedValue(image.Kind, ImageKindPoster, ImageKindBackdrop), "kind", "must be poster or backdrop")
+	v.Check(validator.PermittedValue(image.ContentType, "image/jpeg", "image/png", "image/gif"), "image", "must be a JPEG, PNG or GIF image")
+
+	v.Check(image.Width <= 10_000 && image.Height <= 10_000, "image", "must not be more than 10000 pixels wide or high")
+
+	switch image.Kind {
+	case ImageKindPoster:
+		v.Check(image.Width >= 200 && image.Height >= 300, "image", "must be at least 200x300 pixels for a poster")
+		v.Check(image.Height > image.Width, "image", "must be in portrait orientation for a poster")
+	case ImageKindBackdrop:
+		v.Check(image.Width >= 640 && image.Height >= 360, "image", "must be at least 640x360 pixels for a backdrop")
+		v.Check(image.Width > image.Height, "image", "must be in landscape orientation for a backdrop")
+	}
+}
+
+type MovieImageModel struct {
+	DB *sql.DB
+}
+
+func (m MovieImageModel) Insert(image *MovieImage) error {
+	query := `
+		INSERT INTO movie_images (movie_id, kind, content_type, width, height, size, key, thumbnail_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	args := []any{image.MovieID, image.Kind, image.ContentType, image.Width, image.Height, image.Size, image.Key, image.ThumbnailKey}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.CreatedAt)
+}
+
+// Get returns an image, as long as it belongs to the given movie.
+func (m MovieImageModel) Get(movieID, id int64) (*MovieImage, error) {
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, movie_id, kind, content_type, width, height, size, key, thumbnail_key
+		FROM movie_images
+		WHERE movie_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	image, err := scanMovieImage(m.DB.QueryRowContext(ctx, query, movieID, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return image, nil
+}
+
+// GetForMovies returns the images for each of the given movies, keyed by movie id, with
+// posters before backdrops and the newest first.
+func (m MovieImageModel) GetForMovies(movieIDs []int64) (map[int64][]*MovieImage, error) {
+	query := `
+		SELECT id, created_at, movie_id, kind, content_type, width, height, size, key, thumbnail_key
+		FROM movie_images
+		WHERE movie_id = ANY($1)
+		ORDER BY movie_id, kind DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := make(map[int64][]*MovieImage)
+
+	for rows.Next() {
+		image, err := scanMovieImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images[image.MovieID] = append(images[image.MovieID], image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+func (m MovieImageModel) Delete(movieID, id int64) error {
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM movie_images
+		WHERE movie_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func scanMovieImage(row interface{ Scan(...any) error }) (*MovieImage, error) {
+	var image MovieImage
+
+	err := row.Scan(
+		&image.ID,
+		&image.CreatedAt,
+		&image.MovieID,
+		&image.Kind,
+		&image.ContentType,
+		&image.Width,
+		&image.Height,
+		&image.Size,
+		&image.Key,
+		&image.ThumbnailKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"greenlight.natenine.com/internal/validator"
+)
+
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+
+	// In atomic mode either every row is imported or none are. In partial mode valid
+	// rows are imported and the failing ones are reported back.
+	ImportModeAtomic  = "atomic"
+	ImportModePartial = "partial"
+
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// ImportRowError holds the errors for a single row of an import file. Row is the line
+// number in the uploaded file, counting from 1.
+type ImportRowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+type MovieImport struct {
+	ID          int64            `json:"id"`
+	CreatedAt   time.Time        `json:"created_at"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+	UserID      int64            `json:"-"`
+	Format      string           `json:"format"`
+	Mode        string           `json:"mode"`
+	Status      string           `json:"status"`
+	TotalRows   int              `json:"total_rows"`
+	Imported    int              `json:"imported"`
+	Failed      int              `json:"failed"`
+	Errors      []ImportRowError `json:"errors"`
+}
+
+func ValidateMovieImport(v *validator.Validator, imp *MovieImport) {
+	v.Check(validator.PermittedValue(imp.Format, ImportFormatCSV, ImportFormatNDJSON), "format", "must be csv or ndjson")
+	v.Check(validator.PermittedValue(imp.Mode, ImportModeAtomic, ImportModePartial), "mode", "must be atomic or partial")
+}
+
+type MovieImportModel struct {
+	DB *sql.DB
+}
+
+func (m MovieImportModel) Insert(imp *MovieImport) error {
+	js, err := json.Marshal(imp.Errors)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_imports (user_id, format, mode, status, total_rows, failed, errors)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+
+	args := []any{imp.UserID, imp.Format, imp.Mode, imp.Status, imp.TotalRows, imp.Failed, js}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&imp.ID, &imp.CreatedAt)
+}
+
+func (m MovieImportModel) Get(id int64) (*MovieImport, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, completed_at, user_id, format, mode, status, total_rows, imported, failed, errors
+		FROM movie_imports
+		WHERE id = $1`
+
+	var imp MovieImport
+	var completedAt sql.NullTime
+	var rowErrors []byte
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&imp.ID,
+		&imp.CreatedAt,
+		&completedAt,
+		&imp.UserID,
+		&imp.Format,
+		&imp.Mode,
+		&imp.Status,
+		&imp.TotalRows,
+		&imp.Imported,
+		&imp.Failed,
+		&rowErrors,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if completedAt.Valid {
+		imp.CompletedAt = &completedAt.Time
+	}
+
+	err = json.Unmarshal(rowErrors, &imp.Errors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &imp, nil
+}
+
+// Update saves the progress of an import. It is only ever called by the background
+// goroutine running the import, so there is no need for optimistic locking here.
+func (m MovieImportModel) Update(imp *MovieImport) error {
+	js, err := json.Marshal(imp.Errors)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE movie_imports
+		SET status = $1, imported = $2, failed = $3, errors = $4, completed_at = $5
+		WHERE id = $6`
+
+	args := []any{imp.Status, imp.Imported, imp.Failed, js, imp.CompletedAt, imp.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,219 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// normalisedTitle is the SQL expression used to compare titles when looking for
+// duplicates. It matches the movies_normalised_title_idx index.
+const normalisedTitle = `lower(regexp_replace(title, '[^[:alnum:]]+', '', 'g'))`
+
+// DuplicateGroup is a set of movies which look like the same film: they have the same
+// title, ignoring case and punctuation, and the same year.
+type DuplicateGroup struct {
+	NormalisedTitle string   `json:"normalised_title"`
+	Year            int32    `json:"year"`
+	Movies          []*Movie `json:"movies"`
+}
+
+// GetDuplicates returns a page of possible duplicate movies, grouped by normalised title
+// and year. The movies in each group are ordered by id, so the first is the original.
+func (m MovieModel) GetDuplicates(filters Filters) ([]*DuplicateGroup, Metadata, error) {
+	query := `
+		WITH groups AS (
+			SELECT count(*) OVER() AS total, ` + normalisedTitle + ` AS normalised_title, year
+			FROM movies
+			GROUP BY 2, 3
+			HAVING count(*) > 1
+			ORDER BY 2, 3
+			LIMIT $1 OFFSET $2
+		)
+		SELECT g.total, g.normalised_title, m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version
+		FROM groups g
+		INNER JOIN movies m ON ` + normalisedTitle + ` = g.normalised_title AND m.year = g.year
+		ORDER BY g.normalised_title, g.year, m.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	groups := []*DuplicateGroup{}
+
+	for rows.Next() {
+		var key string
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&key,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if n := len(groups); n == 0 || groups[n-1].NormalisedTitle != key || groups[n-1].Year != movie.Year {
+			groups = append(groups, &DuplicateGroup{NormalisedTitle: key, Year: movie.Year})
+		}
+		group := groups[len(groups)-1]
+		group.Movies = append(group.Movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return groups, metadata, nil
+}
+
+// Merge folds the movie sourceID into targetID and deletes it. The source's external
+// ids, images, watch history and view counts are moved to the target. Its ratings,
+// watchlist entries, translations, release dates, collection memberships and relations
+// to other movies are moved too, unless the target already has a matching one, in which
+// case the target's is kept. Finally a redirect is left behind so that the old id still
+// leads to the merged movie.
+//
+// If version is non-zero the source is only merged if it is still at that version, and
+// ErrEditConflict is returned if it has since changed.
+func (m MovieModel) Merge(sourceID, targetID int64, version int32) error {
+	if sourceID < 1 || targetID < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock both movies, in id order so that two merges of the same pair can't deadlock.
+	// The source's version is read under the lock, so it can't change before the merge
+	// commits.
+	query := `
+		SELECT count(*), coalesce(max(version) FILTER (WHERE id = $1), 0)
+		FROM (
+			SELECT id, version FROM movies
+			WHERE id IN ($1, $2)
+			ORDER BY id
+			FOR UPDATE
+		) AS locked`
+
+	var (
+		found         int
+		sourceVersion int32
+	)
+
+	err = tx.QueryRowContext(ctx, query, sourceID, targetID).Scan(&found, &sourceVersion)
+	if err != nil {
+		return err
+	}
+	if found != 2 {
+		return ErrRecordNotFound
+	}
+	if version != 0 && version != sourceVersion {
+		return ErrEditConflict
+	}
+
+	statements := []string{
+		`UPDATE movie_external_ids SET movie_id = $2 WHERE movie_id = $1`,
+		`UPDATE movie_images SET movie_id = $2 WHERE movie_id = $1`,
+		`UPDATE watch_history SET movie_id = $2 WHERE movie_id = $1`,
+		`INSERT INTO movie_ratings (user_id, movie_id, rating, created_at, updated_at)
+			SELECT user_id, $2, rating, created_at, updated_at FROM movie_ratings WHERE movie_id = $1
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO watchlist (user_id, movie_id, added_at)
+			SELECT user_id, $2, added_at FROM watchlist WHERE movie_id = $1
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO movie_translations (movie_id, language, title, synopsis, config, created_at, updated_at)
+			SELECT $2, language, title, synopsis, config, created_at, updated_at FROM movie_translations WHERE movie_id = $1
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO movie_releases (movie_id, country, release_date, certification)
+			SELECT $2, country, release_date, certification FROM movie_releases WHERE movie_id = $1
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO collection_movies (collection_id, movie_id, position)
+			SELECT collection_id, $2, position FROM collection_movies WHERE movie_id = $1
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO movie_relations (movie_id, related_id, kind, created_at)
+			SELECT $2, related_id, kind, created_at FROM movie_relations WHERE movie_id = $1 AND related_id <> $2
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO movie_relations (movie_id, related_id, kind, created_at)
+			SELECT movie_id, $2, kind, created_at FROM movie_relations WHERE related_id = $1 AND movie_id <> $2
+			ON CONFLICT DO NOTHING`,
+		`INSERT INTO movie_view_counts (movie_id, bucket, views)
+			SELECT $2, bucket, views FROM movie_view_counts WHERE movie_id = $1
+			ON CONFLICT (movie_id, bucket) DO UPDATE SET views = movie_view_counts.views + EXCLUDED.views`,
+		`UPDATE movie_redirects SET movie_id = $2 WHERE movie_id = $1`,
+		`INSERT INTO movie_redirects (old_id, movie_id) VALUES ($1, $2)`,
+	}
+
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement, sourceID, targetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = deleteMovie(ctx, tx, sourceID, 0)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.Similar.Invalidate(sourceID, targetID)
+	return nil
+}
+
+// GetRedirect returns the id of the movie which the given id was merged into.
+func (m MovieModel) GetRedirect(id int64) (int64, error) {
+	if id < 1 {
+		return 0, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT movie_id
+		FROM movie_redirects
+		WHERE old_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var movieID int64
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return movieID, nil
+}
@@ -0,0 +1,158 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TrendingMovie is a movie along with the number of times it was viewed during the
+// trending window.
+type TrendingMovie struct {
+	Movie *Movie `json:"movie"`
+	Views int64  `json:"views"`
+}
+
+type PopularityModel struct {
+	DB *sql.DB
+}
+
+// RecordViews adds views to the hourly counts for the hour containing at. The views map
+// holds the estimated number of views of each movie, keyed by movie id. Views of movies
+// which have since been deleted are dropped.
+func (m PopularityModel) RecordViews(views map[int64]float64, at time.Time) error {
+	if len(views) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(views))
+	counts := make([]float64, 0, len(views))
+
+	for id, count := range views {
+		ids = append(ids, id)
+		counts = append(counts, count)
+	}
+
+	query := `
+		INSERT INTO movie_view_counts (movie_id, bucket, views)
+		SELECT v.movie_id, $3, v.views
+		FROM unnest($1::bigint[], $2::double precision[]) AS v(movie_id, views)
+		WHERE EXISTS (SELECT 1 FROM movies WHERE id = v.movie_id)
+		ON CONFLICT (movie_id, bucket) DO UPDATE SET views = movie_view_counts.views + EXCLUDED.views`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(ids), pq.Array(counts), at.Truncate(time.Hour))
+	return err
+}
+
+// Refresh recalculates every movie's popularity score. The score is the movie's view
+// count with each hour's views decayed by their age, so that they count half as much
+// after halfLife. Counts old enough to no longer matter, and no longer needed for the
+// trending lists, are deleted.
+func (m PopularityModel) Refresh(halfLife time.Duration) error {
+	retention := max(8*halfLife, 7*24*time.Hour)
+	cutoff := time.Now().Add(-retention)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE movies
+		SET popularity = scores.score
+		FROM (
+			SELECT movie_id, sum(views * exp(-ln(2.0) * extract(epoch FROM NOW() - bucket)::double precision / $1::double precision)) AS score
+			FROM movie_view_counts
+			WHERE bucket > $2
+			GROUP BY movie_id
+		) AS scores
+		WHERE movies.id = scores.movie_id`
+
+	_, err = tx.ExecContext(ctx, query, halfLife.Seconds(), cutoff)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE movies
+		SET popularity = 0
+		WHERE popularity <> 0
+		AND NOT EXISTS (SELECT 1 FROM movie_view_counts WHERE movie_id = movies.id AND bucket > $1)`
+
+	_, err = tx.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM movie_view_counts WHERE bucket <= $1", cutoff)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Trending returns up to limit of the movies viewed most since the given time, most
+// viewed first.
+func (m PopularityModel) Trending(since time.Time, limit int) ([]*TrendingMovie, error) {
+	query := `
+		SELECT m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version, t.views
+		FROM (
+			SELECT movie_id, round(sum(views))::bigint AS views
+			FROM movie_view_counts
+			WHERE bucket >= $1
+			GROUP BY movie_id
+			ORDER BY views DESC, movie_id
+			LIMIT $2
+		) AS t
+		INNER JOIN movies m ON m.id = t.movie_id
+		ORDER BY t.views DESC, m.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, since.Truncate(time.Hour), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trending := []*TrendingMovie{}
+
+	for rows.Next() {
+		var movie Movie
+		var tm TrendingMovie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&tm.Views,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tm.Movie = &movie
+		trending = append(trending, &tm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return trending, nil
+}
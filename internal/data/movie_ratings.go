@@ -0,0 +1,66 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"greenlight.natenine.com/internal/validator"
+)
+
+// Rating is a user's score for a movie, from 1 to 10.
+type Rating struct {
+	UserID    int64     `json:"-"`
+	MovieID   int64     `json:"movie_id"`
+	Rating    int16     `json:"rating"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateRating(v *validator.Validator, rating *Rating) {
+	v.Check(rating.Rating >= 1 && rating.Rating <= 10, "rating", "must be between 1 and 10")
+}
+
+type RatingModel struct {
+	DB *sql.DB
+}
+
+// Upsert saves a user's rating for a movie, replacing any rating they gave it before.
+func (m RatingModel) Upsert(rating *Rating) error {
+	query := `
+		INSERT INTO movie_ratings (user_id, movie_id, rating)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, movie_id) DO UPDATE
+		SET rating = EXCLUDED.rating, updated_at = NOW()
+		RETURNING created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, rating.UserID, rating.MovieID, rating.Rating).Scan(&rating.CreatedAt, &rating.UpdatedAt)
+}
+
+func (m RatingModel) Delete(userID, movieID int64) error {
+	query := `
+		DELETE FROM movie_ratings
+		WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
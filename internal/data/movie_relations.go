@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// ErrDuplicateRelation is returned when two movies are already related the other way
+// round, e.g. when making a movie the sequel of its own sequel.
+var ErrDuplicateRelation = errors.New("movies are already related")
+
+// RelationKinds are the ways one movie can be related to another. Each is stored from
+// the point of view of the later movie, so "sequel_of" links a sequel to the original.
+var RelationKinds = []string{"sequel_of", "remake_of", "spin_off_of"}
+
+// inverseRelationKinds names each kind of relation from the point of view of the
+// earlier movie.
+var inverseRelationKinds = map[string]string{
+	"sequel_of":   "has_sequel",
+	"remake_of":   "has_remake",
+	"spin_off_of": "has_spin_off",
+}
+
+// MovieRelation is a link from one movie to another. When a movie's relations are
+// listed, those pointing at it are shown with the inverse kind, such as "has_sequel".
+type MovieRelation struct {
+	Kind  string       `json:"kind"`
+	Movie MovieSummary `json:"movie"`
+}
+
+func ValidateMovieRelation(v *validator.Validator, movieID, relatedID int64, kind string) {
+	v.Check(validator.PermittedValue(kind, RelationKinds...), "kind", "must be one of "+strings.Join(RelationKinds, ", "))
+	v.Check(movieID != relatedID, "related_id", "must be a different movie")
+}
+
+type MovieRelationModel struct {
+	DB *sql.DB
+}
+
+// Set relates movieID to relatedID, replacing the kind of any existing relation between
+// them in the same direction.
+func (m MovieRelationModel) Set(movieID, relatedID int64, kind string) error {
+	query := `
+		INSERT INTO movie_relations (movie_id, related_id, kind)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (movie_id, related_id) DO UPDATE SET kind = EXCLUDED.kind`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, movieID, relatedID, kind)
+	if err != nil {
+		var pqErr *pq.Error
+
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "movie_relations_pair_idx":
+			return ErrDuplicateRelation
+		default:
+			return movieReferenceError(err)
+		}
+	}
+
+	return nil
+}
+
+func (m MovieRelationModel) Delete(movieID, relatedID int64) error {
+	query := `
+		DELETE FROM movie_relations
+		WHERE movie_id = $1 AND related_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, movieID, relatedID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForMovie returns the movie's relations in both directions, oldest movie first.
+func (m MovieRelationModel) GetForMovie(movieID int64) ([]*MovieRelation, error) {
+	query := `
+		SELECT r.kind, r.movie_id = $1, m.id, m.title, m.year
+		FROM movie_relations r
+		INNER JOIN movies m ON m.id = CASE WHEN r.movie_id = $1 THEN r.related_id ELSE r.movie_id END
+		WHERE r.movie_id = $1 OR r.related_id = $1
+		ORDER BY m.year, m.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	relations := []*MovieRelation{}
+
+	for rows.Next() {
+		var relation MovieRelation
+		var outgoing bool
+
+		err := rows.Scan(&relation.Kind, &outgoing, &relation.Movie.ID, &relation.Movie.Title, &relation.Movie.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		if !outgoing {
+			relation.Kind = inverseRelationKinds[relation.Kind]
+		}
+		relations = append(relations, &relation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return relations, nil
+}
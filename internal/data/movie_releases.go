@@ -0,0 +1,119 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.natenine.com/internal/validator"
+)
+
+var countryRX = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// MovieRelease is when a movie was released in a country, and the age certification it
+// was given there. Country is an ISO 3166-1 alpha-2 code and ReleaseDate is formatted as
+// YYYY-MM-DD.
+type MovieRelease struct {
+	MovieID       int64  `json:"-"`
+	Country       string `json:"country"`
+	ReleaseDate   string `json:"release_date"`
+	Certification string `json:"certification,omitempty"`
+}
+
+func ValidateMovieRelease(v *validator.Validator, release *MovieRelease) {
+	v.Check(countryRX.MatchString(release.Country), "country", "must be a two-letter ISO 3166-1 country code")
+
+	v.Check(release.ReleaseDate != "", "release_date", "must be provided")
+	if release.ReleaseDate != "" {
+		date, err := time.Parse(time.DateOnly, release.ReleaseDate)
+		v.Check(err == nil, "release_date", "must be a date in YYYY-MM-DD format")
+		v.Check(err != nil || date.Year() >= 1888, "release_date", "must not be before 1888")
+	}
+
+	v.Check(len(release.Certification) <= 20, "certification", "must not be more than 20 bytes long")
+}
+
+type MovieReleaseModel struct {
+	DB *sql.DB
+}
+
+// Upsert saves a release, replacing any existing one for the same country.
+func (m MovieReleaseModel) Upsert(release *MovieRelease) error {
+	query := `
+		INSERT INTO movie_releases (movie_id, country, release_date, certification)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (movie_id, country) DO UPDATE
+		SET release_date = EXCLUDED.release_date, certification = EXCLUDED.certification`
+
+	args := []any{release.MovieID, release.Country, release.ReleaseDate, release.Certification}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (m MovieReleaseModel) Delete(movieID int64, country string) error {
+	query := `
+		DELETE FROM movie_releases
+		WHERE movie_id = $1 AND country = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, country)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForMovies returns the releases of each of the given movies, keyed by movie id and
+// ordered by release date.
+func (m MovieReleaseModel) GetForMovies(movieIDs []int64) (map[int64][]*MovieRelease, error) {
+	query := `
+		SELECT movie_id, country, to_char(release_date, 'YYYY-MM-DD'), certification
+		FROM movie_releases
+		WHERE movie_id = ANY($1)
+		ORDER BY movie_id, release_date, country`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := make(map[int64][]*MovieRelease)
+
+	for rows.Next() {
+		var release MovieRelease
+
+		err := rows.Scan(&release.MovieID, &release.Country, &release.ReleaseDate, &release.Certification)
+		if err != nil {
+			return nil, err
+		}
+		releases[release.MovieID] = append(releases[release.MovieID], &release)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return releases, nil
+}
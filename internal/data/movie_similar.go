@@ -0,0 +1,233 @@
+package data
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MaxSimilarMovies is the most similar movies which can be asked for, and the number
+// which are worked out and cached for each movie.
+const MaxSimilarMovies = 50
+
+// likedRating is the lowest rating which counts as a user liking a movie, for the
+// co-rating signal.
+const likedRating = 7
+
+// SimilarityWeights sets how much each signal counts towards a similarity score. The
+// score is the weighted mean of the signals, each of which is between 0 and 1.
+type SimilarityWeights struct {
+	// Genres is the Jaccard index of the two movies' genres.
+	Genres float64
+	// Year falls from 1 for movies released in the same year to 0 for movies released
+	// 20 or more years apart.
+	Year float64
+	// Title is the trigram similarity of the two titles.
+	Title float64
+	// CoRating is the number of users who liked both movies, relative to the candidate
+	// liked by the most users in common. It is 0 until users have rated the movie.
+	CoRating float64
+}
+
+// SimilarMovie is a movie which is similar to another, with its overall score and the
+// value of each signal which went into it.
+type SimilarMovie struct {
+	Movie   *Movie             `json:"movie"`
+	Score   float64            `json:"score"`
+	Signals map[string]float64 `json:"signals"`
+}
+
+// maxSimilarCacheEntries is the most movies a SimilarCache holds results for. Once it
+// is full, expired entries are swept out, and if that isn't enough the entry closest to
+// expiring makes way for the new one.
+const maxSimilarCacheEntries = 10_000
+
+// SimilarCache holds the similar movies for recently requested movies. Entries expire
+// after a TTL, and are dropped early when MovieModel changes a movie that they involve.
+// A nil *SimilarCache is valid and caches nothing.
+type SimilarCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[int64]similarEntry
+}
+
+type similarEntry struct {
+	movies  []*SimilarMovie
+	expires time.Time
+}
+
+func NewSimilarCache(ttl time.Duration) *SimilarCache {
+	return &SimilarCache{ttl: ttl, entries: make(map[int64]similarEntry)}
+}
+
+func (c *SimilarCache) get(id int64) ([]*SimilarMovie, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.entries, id)
+		return nil, false
+	}
+	return entry.movies, true
+}
+
+func (c *SimilarCache) set(id int64, movies []*SimilarMovie) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	if _, ok := c.entries[id]; !ok && len(c.entries) >= maxSimilarCacheEntries {
+		var (
+			oldest   int64
+			earliest time.Time
+		)
+
+		for other, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, other)
+				continue
+			}
+			if earliest.IsZero() || entry.expires.Before(earliest) {
+				oldest, earliest = other, entry.expires
+			}
+		}
+
+		if len(c.entries) >= maxSimilarCacheEntries {
+			delete(c.entries, oldest)
+		}
+	}
+
+	c.entries[id] = similarEntry{movies: movies, expires: now.Add(c.ttl)}
+}
+
+// Invalidate drops the cached results for the given movies, along with any results which
+// include them, since their scores depend on the movies' details. A changed movie may
+// also have become similar to movies whose results don't include it yet; those results
+// are only refreshed when they expire.
+func (c *SimilarCache) Invalidate(ids ...int64) {
+	if c == nil || len(ids) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, entry := range c.entries {
+		if slices.Contains(ids, id) || slices.ContainsFunc(entry.movies, func(s *SimilarMovie) bool {
+			return slices.Contains(ids, s.Movie.ID)
+		}) {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// GetSimilar returns up to limit movies which are most similar to the movie with the
+// given id, best first. Results are cached, so the weights should not change between
+// calls.
+func (m MovieModel) GetSimilar(id int64, limit int, weights SimilarityWeights) ([]*SimilarMovie, error) {
+	limit = min(limit, MaxSimilarMovies)
+
+	if similar, ok := m.Similar.get(id); ok {
+		return similar[:min(limit, len(similar))], nil
+	}
+
+	// The candidates are limited to movies which share a genre, have a similar title or
+	// have been liked by the same users, so the indexes on genres and title can be used.
+	query := `
+		WITH target AS (
+			SELECT id, title, year, genres FROM movies WHERE id = $1
+		), co_rated AS (
+			SELECT other.movie_id, count(*)::float8 / max(count(*)) OVER () AS score
+			FROM movie_ratings mine
+			INNER JOIN movie_ratings other ON other.user_id = mine.user_id AND other.movie_id <> mine.movie_id
+			WHERE mine.movie_id = $1 AND mine.rating >= $2 AND other.rating >= $2
+			GROUP BY other.movie_id
+		), scored AS (
+			SELECT m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version,
+				coalesce(
+					(SELECT count(*) FROM (SELECT unnest(m.genres) INTERSECT SELECT unnest(t.genres)) g)::float8 /
+					nullif((SELECT count(*) FROM (SELECT unnest(m.genres) UNION SELECT unnest(t.genres)) g), 0),
+				0) AS genres_score,
+				greatest(0, 1 - abs(m.year - t.year) / 20.0)::float8 AS year_score,
+				similarity(m.title, t.title)::float8 AS title_score,
+				coalesce(c.score, 0) AS co_rating_score
+			FROM movies m
+			CROSS JOIN target t
+			LEFT JOIN co_rated c ON c.movie_id = m.id
+			WHERE m.id <> t.id AND (m.genres && t.genres OR m.title % t.title OR c.movie_id IS NOT NULL)
+		)
+		SELECT id, created_at, title, year, runtime, genres, version,
+			genres_score, year_score, title_score, co_rating_score,
+			($3 * genres_score + $4 * year_score + $5 * title_score + $6 * co_rating_score) / ($3 + $4 + $5 + $6) AS score
+		FROM scored
+		ORDER BY score DESC, id ASC
+		LIMIT $7`
+
+	args := []any{id, likedRating, weights.Genres, weights.Year, weights.Title, weights.CoRating, MaxSimilarMovies}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	similar := []*SimilarMovie{}
+
+	for rows.Next() {
+		var movie Movie
+		var genres, year, title, coRating, score float64
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&genres,
+			&year,
+			&title,
+			&coRating,
+			&score,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		similar = append(similar, &SimilarMovie{
+			Movie: &movie,
+			Score: score,
+			Signals: map[string]float64{
+				"genres":    genres,
+				"year":      year,
+				"title":     title,
+				"co_rating": coRating,
+			},
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	m.Similar.set(id, similar)
+
+	return similar[:min(limit, len(similar))], nil
+}
@@ -0,0 +1,222 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieStats is a summary of the movie catalogue.
+type MovieStats struct {
+	TotalMovies  int           `json:"total_movies"`
+	TotalRuntime Runtime       `json:"total_runtime"`
+	Genres       []GenreCount  `json:"genres"`
+	Years        []YearCount   `json:"years"`
+	Decades      []YearCount   `json:"decades"`
+	Runtime      RuntimeStats  `json:"runtime"`
+	Recent       RecentChanges `json:"recent"`
+	GeneratedAt  time.Time     `json:"generated_at"`
+}
+
+type GenreCount struct {
+	Genre string `json:"genre"`
+	Count int    `json:"count"`
+}
+
+// YearCount is the number of movies released in a year or, for decades, in the ten
+// years starting with Year.
+type YearCount struct {
+	Year  int32 `json:"year"`
+	Count int   `json:"count"`
+}
+
+// RuntimeStats describes the spread of movie runtimes, in minutes.
+type RuntimeStats struct {
+	Min    int32   `json:"min"`
+	Max    int32   `json:"max"`
+	Mean   float64 `json:"mean"`
+	P25    float64 `json:"p25"`
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	P90    float64 `json:"p90"`
+}
+
+// RecentChanges counts the movies added, and the existing movies edited, over the last
+// week and month.
+type RecentChanges struct {
+	AddedLast7Days    int `json:"added_last_7_days"`
+	AddedLast30Days   int `json:"added_last_30_days"`
+	UpdatedLast7Days  int `json:"updated_last_7_days"`
+	UpdatedLast30Days int `json:"updated_last_30_days"`
+}
+
+// StatsCache holds the most recently computed catalogue statistics until they are older
+// than its TTL. A nil *StatsCache is valid and caches nothing.
+type StatsCache struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	stats *MovieStats
+}
+
+func NewStatsCache(ttl time.Duration) *StatsCache {
+	return &StatsCache{ttl: ttl}
+}
+
+// get returns the cached statistics, calling compute to refresh them if they have
+// expired. The lock is held while computing, so that requests arriving at the same time
+// wait for one set of queries rather than each running their own.
+func (c *StatsCache) get(compute func() (*MovieStats, error)) (*MovieStats, error) {
+	if c == nil || c.ttl <= 0 {
+		return compute()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stats != nil && time.Since(c.stats.GeneratedAt) < c.ttl {
+		return c.stats, nil
+	}
+
+	stats, err := compute()
+	if err != nil {
+		return nil, err
+	}
+
+	c.stats = stats
+	return stats, nil
+}
+
+// GetStats returns statistics about the whole catalogue. They are cached for the TTL of
+// m.StatsCache, so may be that much out of date; GeneratedAt says when they were taken.
+func (m MovieModel) GetStats() (*MovieStats, error) {
+	return m.StatsCache.get(m.computeStats)
+}
+
+func (m MovieModel) computeStats() (*MovieStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Run every query against the same snapshot, so that the numbers add up.
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stats := MovieStats{
+		Genres:      []GenreCount{},
+		Years:       []YearCount{},
+		Decades:     []YearCount{},
+		GeneratedAt: time.Now(),
+	}
+
+	query := `
+		SELECT count(*),
+			coalesce(sum(runtime), 0),
+			coalesce(min(runtime), 0),
+			coalesce(max(runtime), 0),
+			coalesce(avg(runtime), 0),
+			percentile_cont(ARRAY[0.25, 0.5, 0.75, 0.9]) WITHIN GROUP (ORDER BY runtime),
+			count(*) FILTER (WHERE created_at > NOW() - INTERVAL '7 days'),
+			count(*) FILTER (WHERE created_at > NOW() - INTERVAL '30 days')
+		FROM movies`
+
+	var percentiles pq.Float64Array
+
+	err = tx.QueryRowContext(ctx, query).Scan(
+		&stats.TotalMovies,
+		&stats.TotalRuntime,
+		&stats.Runtime.Min,
+		&stats.Runtime.Max,
+		&stats.Runtime.Mean,
+		&percentiles,
+		&stats.Recent.AddedLast7Days,
+		&stats.Recent.AddedLast30Days,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// The percentiles are null when there are no movies.
+	if len(percentiles) == 4 {
+		stats.Runtime.P25, stats.Runtime.Median, stats.Runtime.P75, stats.Runtime.P90 = percentiles[0], percentiles[1], percentiles[2], percentiles[3]
+	}
+
+	// Only versions after the first are edits; the first is recorded when a movie is
+	// created.
+	query = `
+		SELECT count(DISTINCT movie_id) FILTER (WHERE created_at > NOW() - INTERVAL '7 days'),
+			count(DISTINCT movie_id)
+		FROM movie_versions
+		WHERE version > 1 AND created_at > NOW() - INTERVAL '30 days'`
+
+	err = tx.QueryRowContext(ctx, query).Scan(&stats.Recent.UpdatedLast7Days, &stats.Recent.UpdatedLast30Days)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT genre, count(*)
+		FROM movies, unnest(genres) AS genre
+		GROUP BY genre
+		ORDER BY count(*) DESC, genre`
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var gc GenreCount
+
+		err := rows.Scan(&gc.Genre, &gc.Count)
+		if err != nil {
+			return nil, err
+		}
+		stats.Genres = append(stats.Genres, gc)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Count by year and by decade in one pass. GROUPING(year) is 1 on the rows for the
+	// decade grouping set, where year itself is null.
+	query = `
+		SELECT GROUPING(year) = 1, coalesce(year, year / 10 * 10), count(*)
+		FROM movies
+		GROUP BY GROUPING SETS ((year), (year / 10 * 10))
+		ORDER BY 1, 2`
+
+	rows, err = tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var decade bool
+		var yc YearCount
+
+		err := rows.Scan(&decade, &yc.Year, &yc.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		if decade {
+			stats.Decades = append(stats.Decades, yc)
+		} else {
+			stats.Years = append(stats.Years, yc)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
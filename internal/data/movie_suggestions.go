@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// MovieSuggestion is a lightweight search result for title autocompletion.
+type MovieSuggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year"`
+}
+
+// likeEscaper escapes the LIKE wildcard characters in user input.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Suggest returns up to limit movies whose titles start with, or are similar to, q. Prefix
+// matches come first, then the closest matches by trigram word similarity, so partly
+// typed and misspelled titles both find something. Both conditions are served by the
+// trigram index on title.
+func (m MovieModel) Suggest(q string, limit int) ([]*MovieSuggestion, error) {
+	query := `
+		SELECT id, title, year
+		FROM movies
+		WHERE title ILIKE $2 OR $1 <% title
+		ORDER BY title ILIKE $2 DESC, word_similarity($1, title) DESC, title ASC, id ASC
+		LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q, likeEscaper.Replace(q)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*MovieSuggestion{}
+
+	for rows.Next() {
+		var s MovieSuggestion
+
+		err := rows.Scan(&s.ID, &s.Title, &s.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// MovieTranslation is the title and synopsis of a movie in another language. Language is
+// a canonical BCP 47 tag.
+type MovieTranslation struct {
+	MovieID   int64     `json:"-"`
+	Language  string    `json:"language"`
+	Title     string    `json:"title"`
+	Synopsis  string    `json:"synopsis,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateMovieTranslation(v *validator.Validator, tr *MovieTranslation) {
+	_, ok := CanonicalLanguageTag(tr.Language)
+	v.Check(ok, "language", "must be a valid BCP 47 language tag")
+
+	v.Check(tr.Title != "", "title", "must be provided")
+	v.Check(len(tr.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(tr.Synopsis) <= 10_000, "synopsis", "must not be more than 10000 bytes long")
+}
+
+type MovieTranslationModel struct {
+	DB *sql.DB
+}
+
+// Upsert saves a translation, replacing any existing one for the same language. The
+// text search configuration for the title is chosen from the language.
+func (m MovieTranslationModel) Upsert(tr *MovieTranslation) error {
+	query := `
+		INSERT INTO movie_translations (movie_id, language, title, synopsis, config)
+		VALUES ($1, $2, $3, $4, $5::regconfig)
+		ON CONFLICT (movie_id, language) DO UPDATE
+		SET title = EXCLUDED.title, synopsis = EXCLUDED.synopsis, config = EXCLUDED.config, updated_at = NOW()
+		RETURNING updated_at`
+
+	args := []any{tr.MovieID, tr.Language, tr.Title, tr.Synopsis, SearchConfig(tr.Language)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&tr.UpdatedAt)
+}
+
+func (m MovieTranslationModel) Delete(movieID int64, language string) error {
+	query := `
+		DELETE FROM movie_translations
+		WHERE movie_id = $1 AND language = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, language)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForMovies returns the translations of each of the given movies, keyed by movie id
+// and ordered by language.
+func (m MovieTranslationModel) GetForMovies(movieIDs []int64) (map[int64][]*MovieTranslation, error) {
+	query := `
+		SELECT movie_id, language, title, synopsis, updated_at
+		FROM movie_translations
+		WHERE movie_id = ANY($1)
+		ORDER BY movie_id, language`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := make(map[int64][]*MovieTranslation)
+
+	for rows.Next() {
+		var tr MovieTranslation
+
+		err := rows.Scan(&tr.MovieID, &tr.Language, &tr.Title, &tr.Synopsis, &tr.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		translations[tr.MovieID] = append(translations[tr.MovieID], &tr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return translations, nil
+}
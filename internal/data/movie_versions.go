@@ -0,0 +1,323 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// FieldChange holds the value of a single movie field before and after an edit. From is
+// null for fields set when the movie was first created.
+type FieldChange struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// MovieVersion is a snapshot of a movie as it was at a given version, along with who
+// made the change and a field-level diff against the previous version.
+type MovieVersion struct {
+	MovieID   int64                  `json:"movie_id"`
+	Version   int32                  `json:"version"`
+	CreatedAt time.Time              `json:"created_at"`
+	UserID    *int64                 `json:"user_id"`
+	Title     string                 `json:"title"`
+	Year      int32                  `json:"year,omitempty"`
+	Runtime   Runtime                `json:"runtime,omitempty"`
+	Genres    []string               `json:"genres,omitempty"`
+	Changes   map[string]FieldChange `json:"changes"`
+}
+
+// diffMovies returns the fields which differ between two movies. If previous is nil,
+// every field of current is reported as a change.
+func diffMovies(previous, current *Movie) (map[string]FieldChange, error) {
+	changes := make(map[string]FieldChange)
+
+	add := func(field string, from, to any) error {
+		var change FieldChange
+		var err error
+
+		if from != nil {
+			change.From, err = json.Marshal(from)
+			if err != nil {
+				return err
+			}
+		}
+
+		change.To, err = json.Marshal(to)
+		if err != nil {
+			return err
+		}
+
+		changes[field] = change
+		return nil
+	}
+
+	if previous == nil {
+		for field, value := range map[string]any{
+			"title":   current.Title,
+			"year":    current.Year,
+			"runtime": current.Runtime,
+			"genres":  current.Genres,
+		} {
+			if err := add(field, nil, value); err != nil {
+				return nil, err
+			}
+		}
+		return changes, nil
+	}
+
+	var err error
+
+	if previous.Title != current.Title {
+		err = errors.Join(err, add("title", previous.Title, current.Title))
+	}
+	if previous.Year != current.Year {
+		err = errors.Join(err, add("year", previous.Year, current.Year))
+	}
+	if previous.Runtime != current.Runtime {
+		err = errors.Join(err, add("runtime", previous.Runtime, current.Runtime))
+	}
+	if !slices.Equal(previous.Genres, current.Genres) {
+		err = errors.Join(err, add("genres", previous.Genres, current.Genres))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+type MovieVersionModel struct {
+	DB *sql.DB
+}
+
+// insertMovieVersion records the current state of the movie in the history table. It
+// runs inside the transaction which wrote the movie, so a version is never recorded for
+// a change which was rolled back.
+func insertMovieVersion(ctx context.Context, tx *sql.Tx, previous, movie *Movie, userID int64) error {
+	changes, err := diffMovies(previous, movie)
+	if err != nil {
+		return err
+	}
+
+	js, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_versions (movie_id, version, user_id, title, year, runtime, genres, changes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	args := []any{
+		movie.ID,
+		movie.Version,
+		sql.NullInt64{Int64: userID, Valid: userID > 0},
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(movie.Genres),
+		js,
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// insertFirstMovieVersions records each of a batch of newly created movies as the first
+// entry in its revision history, as insertMovieVersion does for one, with a single
+// statement.
+func insertFirstMovieVersions(ctx context.Context, tx *sql.Tx, movies []*Movie, userID int64) error {
+	ids := make([]int64, len(movies))
+	versions := make([]int32, len(movies))
+	titles := make([]string, len(movies))
+	years := make([]int32, len(movies))
+	runtimes := make([]int32, len(movies))
+	genres := make([]string, len(movies))
+	changes := make([]string, len(movies))
+
+	for i, movie := range movies {
+		diff, err := diffMovies(nil, movie)
+		if err != nil {
+			return err
+		}
+
+		js, err := json.Marshal(diff)
+		if err != nil {
+			return err
+		}
+
+		genres[i], err = arrayLiteral(movie.Genres)
+		if err != nil {
+			return err
+		}
+
+		ids[i] = movie.ID
+		versions[i] = movie.Version
+		titles[i] = movie.Title
+		years[i] = movie.Year
+		runtimes[i] = int32(movie.Runtime)
+		changes[i] = string(js)
+	}
+
+	query := `
+		INSERT INTO movie_versions (movie_id, version, user_id, title, year, runtime, genres, changes)
+		SELECT v.movie_id, v.version, $1, v.title, v.year, v.runtime, v.genres::text[], v.changes::jsonb
+		FROM unnest($2::bigint[], $3::integer[], $4::text[], $5::integer[], $6::integer[], $7::text[], $8::text[])
+			AS v(movie_id, version, title, year, runtime, genres, changes)`
+
+	args := []any{
+		sql.NullInt64{Int64: userID, Valid: userID > 0},
+		pq.Array(ids),
+		pq.Array(versions),
+		pq.Array(titles),
+		pq.Array(years),
+		pq.Array(runtimes),
+		pq.Array(genres),
+		pq.Array(changes),
+	}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (m MovieVersionModel) Get(movieID int64, version int32) (*MovieVersion, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT movie_id, version, created_at, user_id, title, year, runtime, genres, changes
+		FROM movie_versions
+		WHERE movie_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	mv, err := scanMovieVersion(m.DB.QueryRowContext(ctx, query, movieID, version))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return mv, nil
+}
+
+func (m MovieVersionModel) GetAll(movieID int64, filters Filters) ([]*MovieVersion, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movie_id, version, created_at, user_id, title, year, runtime, genres, changes
+		FROM movie_versions
+		WHERE movie_id = $1
+		ORDER BY %s %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	versions := []*MovieVersion{}
+
+	for rows.Next() {
+		mv, err := scanMovieVersion(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		versions = append(versions, mv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return versions, metadata, nil
+}
+
+// GetForMovies returns the revision history of each of the given movies, newest version
+// first, keyed by movie id.
+func (m MovieVersionModel) GetForMovies(movieIDs []int64) (map[int64][]*MovieVersion, error) {
+	query := `
+		SELECT movie_id, version, created_at, user_id, title, year, runtime, genres, changes
+		FROM movie_versions
+		WHERE movie_id = ANY($1)
+		ORDER BY movie_id, version DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int64][]*MovieVersion)
+
+	for rows.Next() {
+		mv, err := scanMovieVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions[mv.MovieID] = append(versions[mv.MovieID], mv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// scanMovieVersion reads a movie_versions row from either *sql.Row or *sql.Rows. Any
+// extra destinations (such as a window count) are scanned before the version columns.
+func scanMovieVersion(row interface{ Scan(...any) error }, extra ...any) (*MovieVersion, error) {
+	var mv MovieVersion
+	var userID sql.NullInt64
+	var changes []byte
+
+	dest := append(extra,
+		&mv.MovieID,
+		&mv.Version,
+		&mv.CreatedAt,
+		&userID,
+		&mv.Title,
+		&mv.Year,
+		&mv.Runtime,
+		pq.Array(&mv.Genres),
+		&changes,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID.Valid {
+		mv.UserID = &userID.Int64
+	}
+
+	err = json.Unmarshal(changes, &mv.Changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mv, nil
+}
@@ -5,6 +5,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -19,9 +22,26 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+
+	// Relevance and Highlight are only set on the results of a title search. Highlight
+	// is the title with the matching words wrapped in <mark> tags.
+	Relevance float32 `json:"relevance,omitempty"`
+	Highlight string  `json:"highlight,omitempty"`
+
+	// Popularity is the movie's time-decayed view count, which is only read when listing
+	// movies.
+	Popularity float64 `json:"popularity,omitempty"`
+
+	// When a movie is shown in another language, Title and Synopsis come from the
+	// translation for Language, and OriginalTitle holds the stored title.
+	Language      string `json:"language,omitempty"`
+	OriginalTitle string `json:"original_title,omitempty"`
+	Synopsis      string `json:"synopsis,omitempty"`
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// ValidateMovie checks a movie before it is saved. Its genres are looked up in taxonomy:
+// aliases are replaced with the canonical genre slugs, and unknown genres are rejected.
+func ValidateMovie(v *validator.Validator, movie *Movie, taxonomy *GenreTaxonomy) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 
@@ -35,14 +55,101 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Genres != nil, "genres", "must be provided")
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+
+	for i, name := range movie.Genres {
+		slug, ok := taxonomy.Canonical(name)
+		if !ok {
+			v.AddError("genres", fmt.Sprintf("contains unknown genre %q", name))
+			continue
+		}
+		movie.Genres[i] = slug
+	}
+
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
 type MovieModel struct {
-	DB *sql.DB
+	DB         *sql.DB
+	Similar    *SimilarCache
+	StatsCache *StatsCache
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+// Insert creates a new movie and records it as the first entry in the movie's revision
+// history. The userID identifies who created the movie.
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertMovie(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertBatchSize is the number of movies InsertAll creates with each statement.
+const insertBatchSize = 1000
+
+// InsertAll inserts the movies in a single transaction, so that either all of them are
+// created or none are. The movies are inserted insertBatchSize at a time, with one
+// statement per table for each batch rather than one per movie, so a failure can't be
+// traced back to a single movie.
+func (m MovieModel) InsertAll(movies []*Movie, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(movies); start += insertBatchSize {
+		err = insertMovies(ctx, tx, movies[start:min(start+insertBatchSize, len(movies))], userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// InsertEach inserts the movies in a single transaction, wrapping each insert in a
+// savepoint so that a failing row doesn't abort the rest. The returned slice holds the
+// error (or nil) for each movie, in order.
+func (m MovieModel) InsertEach(movies []*Movie, userID int64) ([]error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rowErrors := make([]error, len(movies))
+
+	for i, movie := range movies {
+		err = withSavepoint(ctx, tx, func() error {
+			rowErrors[i] = insertMovie(ctx, tx, movie, userID)
+			return rowErrors[i]
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rowErrors, tx.Commit()
+}
+
+func insertMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
 	query := `
 		INSERT INTO movies(title, year, runtime, genres)
 		VALUES ($1, $2, $3, $4)
@@ -50,38 +157,162 @@ func (m MovieModel) Insert(movie *Movie) error {
 
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = insertMovieVersion(ctx, tx, nil, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	err = recordMovieEvent(ctx, tx, MovieCreated, movie.ID, movie.Version)
+	if err != nil {
+		return err
+	}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return enqueueWebhooks(ctx, tx, EventMovieCreated, movie)
+}
+
+// insertMovies creates a batch of movies, as insertMovie does for one, with a statement
+// per table for the whole batch.
+func insertMovies(ctx context.Context, tx *sql.Tx, movies []*Movie, userID int64) error {
+	// The ids are taken from the sequence up front, so that the rows returned by the
+	// insert can be matched up with their movies.
+	rows, err := tx.QueryContext(ctx, "SELECT nextval(pg_get_serial_sequence('movies', 'id')) FROM generate_series(1, $1)", len(movies))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]*Movie, len(movies))
+
+	for i := 0; rows.Next(); i++ {
+		err := rows.Scan(&movies[i].ID)
+		if err != nil {
+			return err
+		}
+		byID[movies[i].ID] = movies[i]
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	ids := make([]int64, len(movies))
+	titles := make([]string, len(movies))
+	years := make([]int32, len(movies))
+	runtimes := make([]int32, len(movies))
+	genres := make([]string, len(movies))
+
+	for i, movie := range movies {
+		genres[i], err = arrayLiteral(movie.Genres)
+		if err != nil {
+			return err
+		}
+
+		ids[i] = movie.ID
+		titles[i] = movie.Title
+		years[i] = movie.Year
+		runtimes[i] = int32(movie.Runtime)
+	}
+
+	query := `
+		INSERT INTO movies (id, title, year, runtime, genres)
+		SELECT m.id, m.title, m.year, m.runtime, m.genres::text[]
+		FROM unnest($1::bigint[], $2::text[], $3::integer[], $4::integer[], $5::text[]) AS m(id, title, year, runtime, genres)
+		RETURNING id, created_at, version`
+
+	args := []any{pq.Array(ids), pq.Array(titles), pq.Array(years), pq.Array(runtimes), pq.Array(genres)}
+
+	rows, err = tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var createdAt time.Time
+		var version int32
+
+		err := rows.Scan(&id, &createdAt, &version)
+		if err != nil {
+			return err
+		}
+
+		byID[id].CreatedAt = createdAt
+		byID[id].Version = version
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	err = insertFirstMovieVersions(ctx, tx, movies, userID)
+	if err != nil {
+		return err
+	}
+
+	err = recordMovieEvents(ctx, tx, MovieCreated, movies)
+	if err != nil {
+		return err
+	}
+
+	data := make([]any, len(movies))
+	for i, movie := range movies {
+		data[i] = movie
+	}
+
+	return enqueueWebhooks(ctx, tx, EventMovieCreated, data...)
+}
+
+// arrayLiteral writes values as a Postgres array literal, for passing an array of arrays
+// to unnest() as text to be cast back to text[] row by row.
+func arrayLiteral(values []string) (string, error) {
+	literal, err := pq.StringArray(values).Value()
+	if err != nil || literal == nil {
+		return "{}", err
+	}
+	return literal.(string), nil
 }
 
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	return m.GetFields(id, nil)
+}
+
+// GetFields works like Get, but if fields is non-empty only those fields are read, along
+// with the id and version, and the rest of the movie is left at its zero value.
+func (m MovieModel) GetFields(id int64, fields []string) (*Movie, error) {
 	// Because the id can not be <1, an error will be thrown straight away
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+	columns := []string{"id", "created_at", "title", "year", "runtime", "genres", "version"}
+	if len(fields) > 0 {
+		columns = slices.DeleteFunc(columns, func(column string) bool {
+			return column != "id" && column != "version" && !slices.Contains(fields, column)
+		})
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM movies
-		WHERE id=$1`
+		WHERE id=$1`, strings.Join(columns, ", "))
 
 	var movie Movie
 
+	dest := make([]any, len(columns))
+	for i, column := range columns {
+		dest[i] = movie.columnDest(column)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
-
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(dest...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -94,9 +325,63 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// Update saves the changes to a movie, provided that the movie is still at the version
+// held in movie.Version, and records the new version along with a diff of the changed
+// fields in the revision history.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateMovie(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.Similar.Invalidate(movie.ID)
+	return nil
+}
 
+func updateMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
+	// Lock the row at the expected version so that we can diff against it. If the row
+	// has already moved on to another version this is an edit conflict.
 	query := `
+		SELECT id, created_at, title, year, runtime, genres, version
+		FROM movies
+		WHERE id = $1 AND version = $2
+		FOR UPDATE`
+
+	var previous Movie
+
+	err := tx.QueryRowContext(ctx, query, movie.ID, movie.Version).Scan(
+		&previous.ID,
+		&previous.CreatedAt,
+		&previous.Title,
+		&previous.Year,
+		&previous.Runtime,
+		pq.Array(&previous.Genres),
+		&previous.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query = `
 		UPDATE movies
 		SET title=$1, year=$2, runtime=$3, genres=$4, version = version + 1
 		WHERE id=$5 AND version=$6
@@ -111,10 +396,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -123,49 +405,163 @@ func (m MovieModel) Update(movie *Movie) error {
 			return err
 		}
 	}
-	return nil
+
+	err = insertMovieVersion(ctx, tx, &previous, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	err = recordMovieEvent(ctx, tx, MovieUpdated, movie.ID, movie.Version)
+	if err != nil {
+		return err
+	}
+
+	return enqueueWebhooks(ctx, tx, EventMovieUpdated, movie)
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete removes a movie. If version is non-zero the movie is only deleted if it is
+// still at that version, and ErrEditConflict is returned if it has since changed.
+func (m MovieModel) Delete(id int64, version int32) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := `
-		DELETE FROM movies 
-		WHERE id=$1`
-
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	res, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := res.RowsAffected()
+	defer tx.Rollback()
+
+	err = deleteMovie(ctx, tx, id, version)
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+
+	err = tx.Commit()
+	if err != nil {
+		return err
 	}
+
+	m.Similar.Invalidate(id)
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
-	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-		FROM movies
-		WHERE (to_tsvector('english', title)@@ plainto_tsquery('english', $1) OR $1 = '')
-		AND (genres @> $2 OR $2 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+func deleteMovie(ctx context.Context, tx *sql.Tx, id int64, version int32) error {
+	query := `
+		DELETE FROM movies 
+		WHERE id=$1 AND (version=$2 OR $2=0)
+		RETURNING version`
+
+	var deleted int32
+
+	err := tx.QueryRowContext(ctx, query, id, version).Scan(&deleted)
+	if err == nil {
+		err = recordMovieEvent(ctx, tx, MovieDeleted, id, deleted)
+		if err != nil {
+			return err
+		}
+		return enqueueWebhooks(ctx, tx, EventMovieDeleted, map[string]any{"id": id, "version": deleted})
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if version == 0 {
+		return ErrRecordNotFound
+	}
+
+	// Work out whether nothing was deleted because the movie doesn't exist, or because
+	// it has moved on to another version.
+	var exists bool
+
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM movies WHERE id=$1)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrEditConflict
+	}
+	return ErrRecordNotFound
+}
 
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+// GetAll returns a page of the movies matching movieFilters. Pages are found either by
+// page number or, when filters.After or filters.Before is set, by keyset. The total
+// number of matches is only counted if filters.IncludeTotal is set. Title searches can
+// be sorted by relevance. If fields is non-empty only those fields are read, and the
+// rest of each movie is left at its zero value.
+func (m MovieModel) GetAll(movieFilters MovieFilters, filters Filters, fields []string) ([]*Movie, Metadata, error) {
+	where, args := movieFilters.where(nil)
+	countArgs := args
+
+	// Rank and highlight the results when searching by title. The ranking is done in a
+	// subquery so that the keyset condition and ORDER BY can refer to it by name.
+	relevance, highlight, translation := "0::real", "''", ""
+
+	const headlineOptions = "'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'"
+
+	if movieFilters.Title != "" {
+		args = append(args, movieFilters.Title)
+		title := fmt.Sprintf("$%d", len(args))
+
+		tsquery := fmt.Sprintf("websearch_to_tsquery('english', %s)", title)
+		relevance = fmt.Sprintf("ts_rank_cd(to_tsvector('english', title), %s)", tsquery)
+		highlight = fmt.Sprintf("ts_headline('english', title, %s, %s)", tsquery, headlineOptions)
+
+		// When searching in a language, a match on the movie's translation counts too, and
+		// the translated title is highlighted if that's what matched. The best translation
+		// is an exact match for the language tag, then any other in the same language.
+		if movieFilters.Language != "" {
+			args = append(args, BaseLanguage(movieFilters.Language), movieFilters.Language)
+
+			translation = fmt.Sprintf(`
+				LEFT JOIN LATERAL (
+					SELECT tr.title AS tr_title, tr.config AS tr_config
+					FROM movie_translations tr
+					WHERE tr.movie_id = movies.id AND split_part(tr.language, '-', 1) = $%d
+					ORDER BY tr.language = $%d DESC, tr.language
+					LIMIT 1
+				) AS translation ON TRUE`, len(args)-1, len(args))
+
+			trQuery := fmt.Sprintf("websearch_to_tsquery(tr_config, %s)", title)
+			relevance = fmt.Sprintf("greatest(%s, coalesce(ts_rank_cd(to_tsvector(tr_config, tr_title), %s), 0))", relevance, trQuery)
+			highlight = fmt.Sprintf("CASE WHEN to_tsvector(tr_config, tr_title) @@ %s THEN ts_headline(tr_config, tr_title, %s, %s) ELSE %s END", trQuery, trQuery, headlineOptions, highlight)
+		}
+	}
+
+	keyset, keysetArgs := filters.keyset(filters.sortColumn(), len(args)+1)
+
+	columns := movieColumns(fields, filters.sortColumn())
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM (
+			SELECT id, created_at, title, year, runtime, genres, version, popularity, %s AS relevance, %s AS highlight
+			FROM movies %s
+			WHERE %s
+		) AS movies
+		WHERE %s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d`, strings.Join(columns, ", "), relevance, highlight, translation, where, keyset, filters.orderBy(filters.sortColumn()), len(args)+len(keysetArgs)+1, len(args)+len(keysetArgs)+2)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	totalRecords := -1
+
+	if filters.IncludeTotal {
+		err := m.DB.QueryRowContext(ctx, "SELECT count(*) FROM movies WHERE "+where, countArgs...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	// Fetch one more row than we need, so that we know whether there is a next page.
+	args = append(args, keysetArgs...)
+	args = append(args, filters.limit()+1, filters.offset())
+
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -173,14 +569,163 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 	defer rows.Close()
 
-	totalRecord := 0
 	movies := []*Movie{}
 
+	for rows.Next() {
+		var movie Movie
+
+		dest := make([]any, len(columns))
+		for i, column := range columns {
+			dest[i] = movie.columnDest(column)
+		}
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	movies, metadata := paginate(movies, filters, totalRecords, func(movie *Movie) (string, int64) {
+		return movie.sortValue(filters.sortColumn()), movie.ID
+	})
+
+	return movies, metadata, nil
+
+}
+
+// MovieFieldSafeList holds the fields which clients can select with ?fields=.
+var MovieFieldSafeList = []string{"id", "title", "year", "runtime", "genres", "version", "relevance", "highlight", "popularity"}
+
+func ValidateMovieFields(v *validator.Validator, fields []string) {
+	for _, field := range fields {
+		v.Check(validator.PermittedValue(field, MovieFieldSafeList...), "fields", "invalid field value")
+	}
+}
+
+// movieColumns returns the columns to read for the requested fields. The id and the sort
+// column are always read, as they are needed for pagination. With no fields requested
+// every column is read.
+func movieColumns(fields []string, sortColumn string) []string {
+	if len(fields) == 0 {
+		return []string{"id", "created_at", "title", "year", "runtime", "genres", "version", "relevance", "highlight", "popularity"}
+	}
+
+	columns := []string{"id"}
+
+	for _, column := range append(slices.Clone(fields), sortColumn) {
+		if !slices.Contains(columns, column) {
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
+
+// columnDest returns the scan destination in movie for the named column.
+func (movie *Movie) columnDest(column string) any {
+	switch column {
+	case "id":
+		return &movie.ID
+	case "created_at":
+		return &movie.CreatedAt
+	case "title":
+		return &movie.Title
+	case "year":
+		return &movie.Year
+	case "runtime":
+		return &movie.Runtime
+	case "genres":
+		return pq.Array(&movie.Genres)
+	case "version":
+		return &movie.Version
+	case "relevance":
+		return &movie.Relevance
+	case "highlight":
+		return &movie.Highlight
+	case "popularity":
+		return &movie.Popularity
+	default:
+		panic("unknown movie column: " + column)
+	}
+}
+
+// sortValue returns the value of the named sort column for the movie, formatted as a
+// string for use in a pagination cursor.
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.Itoa(int(movie.Year))
+	case "runtime":
+		return strconv.Itoa(int(movie.Runtime))
+	case "relevance":
+		return strconv.FormatFloat(float64(movie.Relevance), 'g', -1, 32)
+	case "popularity":
+		return strconv.FormatFloat(movie.Popularity, 'g', -1, 64)
+	default:
+		return strconv.FormatInt(movie.ID, 10)
+	}
+}
+
+// Export calls fn for every movie matching movieFilters, in id order. Rows are read
+// through a server-side cursor a batch at a time, so memory use doesn't depend on the
+// size of the result. Unlike the other methods, Export takes a context from the caller,
+// since an export runs for as long as the client keeps reading.
+func (m MovieModel) Export(ctx context.Context, movieFilters MovieFilters, fn func(*Movie) error) error {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	where, args := movieFilters.where(nil)
+
+	query := `
+		DECLARE movie_export NO SCROLL CURSOR FOR
+		SELECT id, created_at, title, year, runtime, genres, version
+		FROM movies
+		WHERE ` + where + `
+		ORDER BY id ASC`
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		n, err := fetchMovies(ctx, tx, "movie_export", 1000, fn)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
+
+// fetchMovies reads the next batch of up to size rows from the named cursor, calling fn
+// for each movie. It returns the number of rows read.
+func fetchMovies(ctx context.Context, tx *sql.Tx, cursor string, size int, fn func(*Movie) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM %s", size, cursor))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+
 	for rows.Next() {
 		var movie Movie
 
 		err := rows.Scan(
-			&totalRecord,
 			&movie.ID,
 			&movie.CreatedAt,
 			&movie.Title,
@@ -190,17 +735,15 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Version,
 		)
 		if err != nil {
-			return nil, Metadata{}, err
+			return n, err
 		}
-		movies = append(movies, &movie)
-	}
 
-	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		err = fn(&movie)
+		if err != nil {
+			return n, err
+		}
+		n++
 	}
 
-	metadata := calculateMetadata(totalRecord, filters.Page, filters.PageSize)
-
-	return movies, metadata, nil
-
+	return n, rows.Err()
 }
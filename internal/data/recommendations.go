@@ -0,0 +1,210 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxRecommendations is the number of recommendations stored for each user.
+const maxRecommendations = 100
+
+// Recommendation is a movie picked for a user from their taste profile.
+type Recommendation struct {
+	Movie       *Movie    `json:"movie"`
+	Score       float64   `json:"score"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+type RecommendationModel struct {
+	DB *sql.DB
+}
+
+// refreshBatchSize is the number of users whose recommendations Refresh works out at a
+// time.
+const refreshBatchSize = 100
+
+// Refresh works out new recommendations for every user, replacing the stored ones. It is
+// run periodically in the background. Users are refreshed in batches, each in its own
+// statement, so that a run doesn't hold locks on the whole table while it works through
+// them.
+//
+// A user's taste profile is an affinity for each genre, built up from the movies they
+// have rated (ratings above the midpoint count for a genre, ratings below it against),
+// put on their watchlist or watched. Candidates are the movies in the genres with a
+// positive affinity, scored by the sum of those affinities and scaled down for movies
+// with lots of genres, so that they don't win just by matching everything. Movies the
+// user has already watched, rated or saved are left out.
+func (m RecommendationModel) Refresh() error {
+	var after int64
+
+	for {
+		userIDs, err := m.nextUsers(after)
+		if err != nil {
+			return err
+		}
+		if len(userIDs) == 0 {
+			return nil
+		}
+
+		err = m.refreshUsers(userIDs)
+		if err != nil {
+			return err
+		}
+
+		after = userIDs[len(userIDs)-1]
+	}
+}
+
+// nextUsers returns the IDs of the next batch of users after the given ID.
+func (m RecommendationModel) nextUsers(after int64) ([]int64, error) {
+	query := `
+		SELECT id
+		FROM users
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, after, refreshBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
+// refreshUsers works out new recommendations for the given users. The new ones are
+// upserted, and any of the users' stored recommendations which didn't make the cut are
+// deleted, in one statement.
+func (m RecommendationModel) refreshUsers(userIDs []int64) error {
+	query := `
+		WITH signals AS (
+			SELECT user_id, movie_id, (rating - 5.5) / 4.5 AS weight FROM movie_ratings WHERE user_id = ANY($1)
+			UNION ALL
+			SELECT user_id, movie_id, 0.5 FROM watchlist WHERE user_id = ANY($1)
+			UNION ALL
+			SELECT user_id, movie_id, 0.3 FROM watch_history WHERE user_id = ANY($1)
+		), seen AS (
+			SELECT user_id, movie_id FROM movie_ratings WHERE user_id = ANY($1)
+			UNION
+			SELECT user_id, movie_id FROM watchlist WHERE user_id = ANY($1)
+			UNION
+			SELECT user_id, movie_id FROM watch_history WHERE user_id = ANY($1)
+		), profile AS (
+			SELECT s.user_id, g.genre, sum(s.weight) AS affinity
+			FROM signals s
+			INNER JOIN movies m ON m.id = s.movie_id
+			CROSS JOIN unnest(m.genres) AS g(genre)
+			GROUP BY s.user_id, g.genre
+			HAVING sum(s.weight) > 0
+		), candidates AS (
+			SELECT p.user_id, m.id AS movie_id, sum(p.affinity) / sqrt(cardinality(m.genres)) AS score
+			FROM profile p
+			INNER JOIN movies m ON m.genres @> ARRAY[p.genre]
+			WHERE NOT EXISTS (SELECT 1 FROM seen WHERE seen.user_id = p.user_id AND seen.movie_id = m.id)
+			GROUP BY p.user_id, m.id
+		), ranked AS (
+			SELECT user_id, movie_id, score
+			FROM (
+				SELECT user_id, movie_id, score, row_number() OVER (PARTITION BY user_id ORDER BY score DESC, movie_id) AS rank
+				FROM candidates
+			) c
+			WHERE rank <= $2
+		), upserted AS (
+			INSERT INTO recommendations (user_id, movie_id, score)
+			SELECT user_id, movie_id, score
+			FROM ranked
+			ON CONFLICT (user_id, movie_id) DO UPDATE
+			SET score = EXCLUDED.score, generated_at = NOW()
+		)
+		DELETE FROM recommendations r
+		WHERE r.user_id = ANY($1)
+		AND NOT EXISTS (SELECT 1 FROM ranked WHERE ranked.user_id = r.user_id AND ranked.movie_id = r.movie_id)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(userIDs), maxRecommendations)
+	return err
+}
+
+// GetForUser returns a page of a user's stored recommendations. Movies which the user
+// has watched or rated since the recommendations were worked out are left out.
+func (m RecommendationModel) GetForUser(userID int64, filters Filters) ([]*Recommendation, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), r.score, r.generated_at, m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version
+		FROM recommendations r
+		INNER JOIN movies m ON m.id = r.movie_id
+		WHERE r.user_id = $1
+		AND NOT EXISTS (SELECT 1 FROM watch_history h WHERE h.user_id = r.user_id AND h.movie_id = r.movie_id)
+		AND NOT EXISTS (SELECT 1 FROM movie_ratings mr WHERE mr.user_id = r.user_id AND mr.movie_id = r.movie_id)
+		ORDER BY %s %s, m.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	recommendations := []*Recommendation{}
+
+	for rows.Next() {
+		var recommendation Recommendation
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&recommendation.Score,
+			&recommendation.GeneratedAt,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		recommendation.Movie = &movie
+		recommendations = append(recommendations, &recommendation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return recommendations, metadata, nil
+}
@@ -3,39 +3,274 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
 
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 
+// Runtime is the length of a movie in minutes. In JSON it is written as "<n> mins" by
+// default, and can be read from any of the forms accepted by ParseRuntime or from a
+// plain JSON number of minutes.
 type Runtime int32
 
+// RuntimeFormat is a way of writing a runtime in a response.
+type RuntimeFormat string
+
+const (
+	// RuntimeFormatMins writes runtimes as a string such as "102 mins". It is the default.
+	RuntimeFormatMins RuntimeFormat = "mins"
+	// RuntimeFormatMinutes writes runtimes as a number of minutes, such as 102.
+	RuntimeFormatMinutes RuntimeFormat = "minutes"
+	// RuntimeFormatISO8601 writes runtimes as an ISO 8601 duration, such as "PT1H42M".
+	RuntimeFormatISO8601 RuntimeFormat = "iso8601"
+)
+
+// RuntimeFormats lists the supported output formats.
+var RuntimeFormats = []RuntimeFormat{RuntimeFormatMins, RuntimeFormatMinutes, RuntimeFormatISO8601}
+
 func (r Runtime) MarshalJSON() ([]byte, error) {
-	jsonValue := fmt.Sprintf("%d mins", r)
-	quotedJSONValue := strconv.Quote(jsonValue)
-	return []byte(quotedJSONValue), nil
+	return r.MarshalFormat(RuntimeFormatMins)
+}
+
+// MarshalFormat returns the JSON encoding of the runtime in the given format.
+func (r Runtime) MarshalFormat(format RuntimeFormat) ([]byte, error) {
+	switch format {
+	case RuntimeFormatMins:
+		return []byte(strconv.Quote(fmt.Sprintf("%d mins", r))), nil
+	case RuntimeFormatMinutes:
+		return []byte(strconv.FormatInt(int64(r), 10)), nil
+	case RuntimeFormatISO8601:
+		return []byte(strconv.Quote(r.iso8601())), nil
+	default:
+		return nil, fmt.Errorf("unknown runtime format %q", format)
+	}
+}
+
+// iso8601 formats the runtime as an ISO 8601 duration of hours and minutes. Negative
+// runtimes, which fail validation but can still be decoded, get a leading minus sign.
+func (r Runtime) iso8601() string {
+	var b strings.Builder
+
+	minutes := int64(r)
+	if minutes < 0 {
+		b.WriteByte('-')
+		minutes = -minutes
+	}
+
+	b.WriteString("PT")
+
+	if hours := minutes / 60; hours > 0 {
+		fmt.Fprintf(&b, "%dH", hours)
+	}
+	if minutes%60 > 0 || minutes == 0 {
+		fmt.Fprintf(&b, "%dM", minutes%60)
+	}
+
+	return b.String()
 }
 
+// UnmarshalJSON accepts a JSON number of minutes, or a string in any of the forms
+// accepted by ParseRuntime. A null leaves the runtime unchanged.
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
-	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	s := string(jsonValue)
+
+	if s == "null" {
+		return nil
+	}
+
+	if !strings.HasPrefix(s, `"`) {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
+		*r = Runtime(n)
+		return nil
+	}
+
+	unquoted, err := strconv.Unquote(s)
 	if err != nil {
 		return ErrInvalidRuntimeFormat
 	}
 
-	// Split the string to isolate the part containing the number.
-	parts := strings.Split(unquotedJSONValue, " ")
+	runtime, err := ParseRuntime(unquoted)
+	if err != nil {
+		return err
+	}
 
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+	*r = runtime
+	return nil
+}
+
+// ParseRuntime reads a runtime written in any of these forms:
+//
+//   - a bare number of minutes: "102"
+//   - hours and minutes with units: "102 mins", "1h 42m", "1h42m", "1 hour 42 minutes"
+//   - an ISO 8601 duration: "PT1H42M", "PT102M", "PT6120S"
+//
+// Units and the ISO 8601 designators are case-insensitive, and surrounding whitespace is
+// ignored. A leading minus sign negates the runtime. Durations must come to a whole
+// number of minutes.
+func ParseRuntime(s string) (Runtime, error) {
+	s = strings.TrimSpace(s)
+
+	negative := false
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		negative, s = true, rest
+	} else {
+		s = strings.TrimPrefix(s, "+")
 	}
 
-	i, err := strconv.ParseInt(parts[0], 10, 32)
+	var minutes int64
+	var err error
+
+	switch {
+	case s == "":
+		return 0, ErrInvalidRuntimeFormat
+	case s[0] == 'P' || s[0] == 'p':
+		minutes, err = parseISO8601Duration(s[1:])
+	default:
+		minutes, err = parseUnitDuration(s)
+	}
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return 0, err
+	}
+
+	if negative {
+		minutes = -minutes
+	}
+	if minutes > math.MaxInt32 || minutes < math.MinInt32 {
+		return 0, ErrInvalidRuntimeFormat
 	}
 
-	*r = Runtime(i)
+	return Runtime(minutes), nil
+}
 
-	return nil
+// runtimeUnits maps the unit names accepted by parseUnitDuration to their length in
+// minutes.
+var runtimeUnits = map[string]int64{
+	"h": 60, "hr": 60, "hrs": 60, "hour": 60, "hours": 60,
+	"m": 1, "min": 1, "mins": 1, "minute": 1, "minutes": 1,
+}
+
+// parseUnitDuration parses a bare number of minutes, or a sequence of numbers each
+// followed by a unit, with hours (if given) before minutes.
+func parseUnitDuration(s string) (int64, error) {
+	var total int64
+	var lastUnit int64 = math.MaxInt64
+
+	for s != "" {
+		digits := len(s) - len(strings.TrimLeft(s, "0123456789"))
+		if digits == 0 {
+			return 0, ErrInvalidRuntimeFormat
+		}
+
+		n, err := strconv.ParseInt(s[:digits], 10, 64)
+		if err != nil {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		s = strings.TrimLeft(s[digits:], " ")
+
+		// A number on its own is only allowed as the whole runtime.
+		if s == "" {
+			if lastUnit != math.MaxInt64 {
+				return 0, ErrInvalidRuntimeFormat
+			}
+			return n, nil
+		}
+
+		letters := len(s) - len(strings.TrimLeft(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+
+		unit, ok := runtimeUnits[strings.ToLower(s[:letters])]
+		if !ok || unit >= lastUnit {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		lastUnit = unit
+		s = strings.TrimLeft(s[letters:], " ")
+
+		total, err = addDuration(total, n, unit, maxRuntimeMagnitude)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return total, nil
+}
+
+// iso8601Units maps the ISO 8601 duration designators accepted by parseISO8601Duration to
+// their length in seconds.
+var iso8601Units = map[byte]int64{'D': 86400, 'H': 3600, 'M': 60, 'S': 1}
+
+// parseISO8601Duration parses the part of an ISO 8601 duration after the leading "P".
+// Days, hours, minutes and seconds are supported; years, months and weeks aren't, as
+// their length in minutes isn't fixed or they make no sense for a movie.
+func parseISO8601Duration(s string) (int64, error) {
+	var seconds int64
+
+	// Each designator must come after the ones before it in this list, and the time
+	// designators (H, M and S) only after the T.
+	const order = "DTHMS"
+	position := -1
+	sawTime, sawValue := false, false
+
+	for s != "" {
+		if s[0] == 'T' || s[0] == 't' {
+			if sawTime {
+				return 0, ErrInvalidRuntimeFormat
+			}
+			sawTime, position, s = true, strings.IndexByte(order, 'T'), s[1:]
+			if s == "" {
+				return 0, ErrInvalidRuntimeFormat
+			}
+			continue
+		}
+
+		digits := len(s) - len(strings.TrimLeft(s, "0123456789"))
+		if digits == 0 || digits == len(s) {
+			return 0, ErrInvalidRuntimeFormat
+		}
+
+		n, err := strconv.ParseInt(s[:digits], 10, 64)
+		if err != nil {
+			return 0, ErrInvalidRuntimeFormat
+		}
+
+		designator := s[digits]
+		if 'a' <= designator && designator <= 'z' {
+			designator -= 'a' - 'A'
+		}
+		s = s[digits+1:]
+
+		i := strings.IndexByte(order, designator)
+		if i <= position || designator == 'T' || (designator != 'D') != sawTime {
+			return 0, ErrInvalidRuntimeFormat
+		}
+		position = i
+
+		seconds, err = addDuration(seconds, n, iso8601Units[designator], maxRuntimeMagnitude*60)
+		if err != nil {
+			return 0, err
+		}
+		sawValue = true
+	}
+
+	if !sawValue || seconds%60 != 0 {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return seconds / 60, nil
+}
+
+// maxRuntimeMagnitude is the largest number of minutes, ignoring the sign, which can be
+// parsed before checking that the runtime fits in a Runtime. It is one more than the
+// largest Runtime so that the smallest negative one can be parsed too.
+const maxRuntimeMagnitude = -math.MinInt32
+
+// addDuration returns total + n*unit, or an error if the result is more than limit.
+func addDuration(total, n, unit, limit int64) (int64, error) {
+	if n > limit/unit || total+n*unit > limit {
+		return 0, ErrInvalidRuntimeFormat
+	}
+	return total + n*unit, nil
 }
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestParseRuntime(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Runtime
+		err   bool
+	}{
+		{name: "bare number", input: "102", want: 102},
+		{name: "zero", input: "0", want: 0},
+		{name: "leading zeros", input: "0102", want: 102},
+		{name: "plus sign", input: "+102", want: 102},
+		{name: "negative", input: "-5", want: -5},
+		{name: "surrounding whitespace", input: "  102 mins\t", want: 102},
+		{name: "mins", input: "102 mins", want: 102},
+		{name: "min", input: "1 min", want: 1},
+		{name: "mins without space", input: "102mins", want: 102},
+		{name: "negative mins", input: "-5 mins", want: -5},
+		{name: "minutes", input: "102 minutes", want: 102},
+		{name: "hours and minutes", input: "1h 42m", want: 102},
+		{name: "hours and minutes without space", input: "1h42m", want: 102},
+		{name: "hours only", input: "2h", want: 120},
+		{name: "minutes only", input: "42m", want: 42},
+		{name: "long units", input: "1 hour 42 minutes", want: 102},
+		{name: "plural hours", input: "2 hours 5 mins", want: 125},
+		{name: "hr", input: "1hr 30min", want: 90},
+		{name: "upper case units", input: "1H 42M", want: 102},
+		{name: "minutes over an hour", input: "1h 90m", want: 150},
+		{name: "iso8601", input: "PT1H42M", want: 102},
+		{name: "iso8601 minutes", input: "PT102M", want: 102},
+		{name: "iso8601 hours", input: "PT2H", want: 120},
+		{name: "iso8601 seconds", input: "PT6120S", want: 102},
+		{name: "iso8601 whole minute seconds", input: "PT1H41M60S", want: 102},
+		{name: "iso8601 days", input: "P1D", want: 1440},
+		{name: "iso8601 days and time", input: "P1DT1H", want: 1500},
+		{name: "iso8601 lower case", input: "pt1h42m", want: 102},
+		{name: "iso8601 negative", input: "-PT1H42M", want: -102},
+		{name: "iso8601 zero", input: "PT0M", want: 0},
+		{name: "largest", input: "2147483647", want: math.MaxInt32},
+		{name: "smallest", input: "-2147483648", want: math.MinInt32},
+		{name: "largest hours", input: "35791394h 7m", want: math.MaxInt32},
+		{name: "smallest iso8601", input: "-PT35791394H8M", want: math.MinInt32},
+
+		{name: "empty", input: "", err: true},
+		{name: "whitespace", input: "   ", err: true},
+		{name: "sign only", input: "-", err: true},
+		{name: "double sign", input: "--5", err: true},
+		{name: "space after sign", input: "- 5", err: true},
+		{name: "unknown unit", input: "102 secs", err: true},
+		{name: "unit without number", input: "mins", err: true},
+		{name: "decimal", input: "1.5h", err: true},
+		{name: "minutes before hours", input: "42m 1h", err: true},
+		{name: "repeated unit", input: "1h 2h", err: true},
+		{name: "trailing number", input: "1h 42", err: true},
+		{name: "trailing junk", input: "102 mins!", err: true},
+		{name: "overflow", input: "2147483648", err: true},
+		{name: "negative overflow", input: "-2147483649", err: true},
+		{name: "hours overflow", input: "35791395h", err: true},
+		{name: "huge number", input: "99999999999999999999", err: true},
+		{name: "huge hours", input: "9999999999999999h", err: true},
+		{name: "iso8601 empty", input: "P", err: true},
+		{name: "iso8601 empty time", input: "PT", err: true},
+		{name: "iso8601 trailing T", input: "P1DT", err: true},
+		{name: "iso8601 missing T", input: "P1H", err: true},
+		{name: "iso8601 days after T", input: "PT1D", err: true},
+		{name: "iso8601 out of order", input: "PT42M1H", err: true},
+		{name: "iso8601 repeated", input: "PT1H1H", err: true},
+		{name: "iso8601 years", input: "P1Y", err: true},
+		{name: "iso8601 weeks", input: "P1W", err: true},
+		{name: "iso8601 fraction", input: "PT1.5H", err: true},
+		{name: "iso8601 part minute", input: "PT90S", err: true},
+		{name: "iso8601 number without designator", input: "PT42", err: true},
+		{name: "iso8601 double T", input: "PT1HT2M", err: true},
+		{name: "iso8601 overflow", input: "PT35791395H", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRuntime(tt.input)
+
+			if tt.err {
+				if !errors.Is(err, ErrInvalidRuntimeFormat) {
+					t.Fatalf("ParseRuntime(%q) = %d, %v; want ErrInvalidRuntimeFormat", tt.input, got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseRuntime(%q) returned error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRuntime(%q) = %d; want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuntimeUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Runtime
+		err   bool
+	}{
+		{name: "mins string", input: `"102 mins"`, want: 102},
+		{name: "number", input: `102`, want: 102},
+		{name: "negative number", input: `-5`, want: -5},
+		{name: "bare number string", input: `"102"`, want: 102},
+		{name: "hours and minutes", input: `"1h 42m"`, want: 102},
+		{name: "iso8601", input: `"PT1H42M"`, want: 102},
+		{name: "escaped string", input: `"1h\u002042m"`, want: 102},
+		{name: "null leaves value", input: `null`, want: 7},
+
+		{name: "float", input: `102.5`, err: true},
+		{name: "exponent", input: `1e2`, err: true},
+		{name: "number overflow", input: `2147483648`, err: true},
+		{name: "boolean", input: `true`, err: true},
+		{name: "invalid string", input: `"long"`, err: true},
+		{name: "unterminated string", input: `"102 mins`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Runtime(7)
+
+			err := got.UnmarshalJSON([]byte(tt.input))
+
+			if tt.err {
+				if !errors.Is(err, ErrInvalidRuntimeFormat) {
+					t.Fatalf("UnmarshalJSON(%s) = %d, %v; want ErrInvalidRuntimeFormat", tt.input, got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("UnmarshalJSON(%s) returned error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("UnmarshalJSON(%s) = %d; want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuntimeMarshalFormat(t *testing.T) {
+	tests := []struct {
+		runtime Runtime
+		format  RuntimeFormat
+		want    string
+	}{
+		{runtime: 102, format: RuntimeFormatMins, want: `"102 mins"`},
+		{runtime: 1, format: RuntimeFormatMins, want: `"1 mins"`},
+		{runtime: 102, format: RuntimeFormatMinutes, want: `102`},
+		{runtime: -5, format: RuntimeFormatMinutes, want: `-5`},
+		{runtime: 102, format: RuntimeFormatISO8601, want: `"PT1H42M"`},
+		{runtime: 120, format: RuntimeFormatISO8601, want: `"PT2H"`},
+		{runtime: 42, format: RuntimeFormatISO8601, want: `"PT42M"`},
+		{runtime: 0, format: RuntimeFormatISO8601, want: `"PT0M"`},
+		{runtime: -102, format: RuntimeFormatISO8601, want: `"-PT1H42M"`},
+		{runtime: math.MinInt32, format: RuntimeFormatISO8601, want: `"-PT35791394H8M"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format)+"/"+strconv.Itoa(int(tt.runtime)), func(t *testing.T) {
+			got, err := tt.runtime.MarshalFormat(tt.format)
+			if err != nil {
+				t.Fatalf("MarshalFormat returned error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MarshalFormat(%q) = %s; want %s", tt.format, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		got, err := json.Marshal(Runtime(102))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != `"102 mins"` {
+			t.Errorf("json.Marshal = %s; want %q", got, "102 mins")
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		_, err := Runtime(102).MarshalFormat("hours")
+		if err == nil {
+			t.Error("MarshalFormat with an unknown format returned no error")
+		}
+	})
+}
+
+// FuzzParseRuntime checks that the parser never panics, and that anything it accepts
+// comes out the same after being written in each format and read back in.
+func FuzzParseRuntime(f *testing.F) {
+	for _, seed := range []string{
+		"102", "-5", "102 mins", "1 min", "1h 42m", "1h42m", "1 hour 42 minutes",
+		"PT1H42M", "PT6120S", "P1DT1H", "-PT1H42M", "2147483647", "-2147483648",
+		"", "P", "PT", "1.5h", "42m 1h", "PT90S",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		r, err := ParseRuntime(s)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidRuntimeFormat) {
+				t.Fatalf("ParseRuntime(%q) returned unexpected error: %v", s, err)
+			}
+			return
+		}
+
+		for _, format := range RuntimeFormats {
+			js, err := r.MarshalFormat(format)
+			if err != nil {
+				t.Fatalf("MarshalFormat(%q) of %d returned error: %v", format, r, err)
+			}
+
+			var got Runtime
+			err = json.Unmarshal(js, &got)
+			if err != nil {
+				t.Fatalf("Unmarshal(%s) returned error: %v", js, err)
+			}
+			if got != r {
+				t.Fatalf("round trip of %q through %s gave %d; want %d", s, js, got, r)
+			}
+		}
+	})
+}
+
+// FuzzRuntimeRoundTrip checks that every runtime can be written in each format and read
+// back unchanged.
+func FuzzRuntimeRoundTrip(f *testing.F) {
+	for _, seed := range []int32{0, 1, 59, 60, 61, 102, -1, -102, math.MaxInt32, math.MinInt32} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, n int32) {
+		for _, format := range RuntimeFormats {
+			js, err := Runtime(n).MarshalFormat(format)
+			if err != nil {
+				t.Fatalf("MarshalFormat(%q) of %d returned error: %v", format, n, err)
+			}
+
+			var got Runtime
+			err = json.Unmarshal(js, &got)
+			if err != nil {
+				t.Fatalf("Unmarshal(%s) returned error: %v", js, err)
+			}
+			if got != Runtime(n) {
+				t.Fatalf("round trip through %s gave %d; want %d", js, got, n)
+			}
+		}
+	})
+}
@@ -111,9 +111,15 @@ func (m UserModel) Insert(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -122,7 +128,13 @@ func (m UserModel) Insert(user *User) error {
 			return err
 		}
 	}
-	return nil
+
+	err = enqueueWebhooks(ctx, tx, EventUserCreated, user)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
@@ -156,12 +168,15 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// Update saves changes to a user, provided it is still at user.Version. If the user is
+// being activated, a user.activated webhook is queued along with the change.
 func (m UserModel) Update(user *User) error {
 	query := `
 			UPDATE users
-			SET name = $1, email= $2, password_hash=$3, activated=$4, version=version + 1
-			WHERE id = $5 AND version=$6
-			RETURNING version`
+			SET name = $1, email= $2, password_hash=$3, activated=$4, version=users.version + 1
+			FROM users old
+			WHERE users.id = $5 AND users.version=$6 AND old.id = users.id
+			RETURNING users.version, old.activated`
 
 	args := []any{
 		user.Name,
@@ -175,7 +190,15 @@ func (m UserModel) Update(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasActivated bool
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.Version, &wasActivated)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "user_email_key"`:
@@ -186,7 +209,15 @@ func (m UserModel) Update(user *User) error {
 			return err
 		}
 	}
-	return nil
+
+	if user.Activated && !wasActivated {
+		err = enqueueWebhooks(ctx, tx, EventUserActivated, user)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (m UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error) {
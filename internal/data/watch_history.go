@@ -0,0 +1,101 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.natenine.com/internal/validator"
+)
+
+// WatchEvent records a user watching a movie. A movie can be watched more than once.
+type WatchEvent struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	MovieID   int64     `json:"-"`
+	Movie     *Movie    `json:"movie,omitempty"`
+	WatchedAt time.Time `json:"watched_at"`
+}
+
+func ValidateWatchEvent(v *validator.Validator, event *WatchEvent) {
+	v.Check(event.MovieID > 0, "movie_id", "must be provided")
+	v.Check(!event.WatchedAt.After(time.Now()), "watched_at", "must not be in the future")
+}
+
+type WatchHistoryModel struct {
+	DB *sql.DB
+}
+
+// Insert records a watch. If WatchedAt is zero the current time is used.
+func (m WatchHistoryModel) Insert(event *WatchEvent) error {
+	query := `
+		INSERT INTO watch_history (user_id, movie_id, watched_at)
+		VALUES ($1, $2, coalesce($3, NOW()))
+		RETURNING id, watched_at`
+
+	var watchedAt *time.Time
+	if !event.WatchedAt.IsZero() {
+		watchedAt = &event.WatchedAt
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, event.UserID, event.MovieID, watchedAt).Scan(&event.ID, &event.WatchedAt)
+}
+
+func (m WatchHistoryModel) GetAll(userID int64, filters Filters) ([]*WatchEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), h.id, h.watched_at, m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version
+		FROM watch_history h
+		INNER JOIN movies m ON m.id = h.movie_id
+		WHERE h.user_id = $1
+		ORDER BY %s %s, h.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*WatchEvent{}
+
+	for rows.Next() {
+		var event WatchEvent
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.WatchedAt,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		event.UserID, event.MovieID, event.Movie = userID, movie.ID, &movie
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
@@ -0,0 +1,381 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrDeliveryInProgress is returned when redelivering a delivery which is being sent.
+	ErrDeliveryInProgress = errors.New("delivery in progress")
+
+	// ErrLeaseLost is returned when recording an attempt at a delivery whose claim has
+	// expired, or which has been redelivered, since it was claimed.
+	ErrLeaseLost = errors.New("delivery lease lost")
+)
+
+// The states of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is an event queued for sending to a webhook. It stays pending, and is
+// retried, until it succeeds or runs out of attempts and fails.
+type WebhookDelivery struct {
+	ID            int64           `json:"id"`
+	WebhookID     int64           `json:"webhook_id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+	CompletedAt   *time.Time      `json:"completed_at,omitempty"`
+
+	// AttemptLog is only filled in when a single delivery is fetched.
+	AttemptLog []*WebhookAttempt `json:"attempt_log,omitempty"`
+}
+
+// WebhookAttempt records one attempt at sending a delivery. ResponseStatus is zero if no
+// response was received, in which case Error says why.
+type WebhookAttempt struct {
+	ID             int64     `json:"id"`
+	AttemptedAt    time.Time `json:"attempted_at"`
+	DurationMS     int64     `json:"duration_ms"`
+	ResponseStatus int       `json:"response_status,omitempty"`
+	ResponseBody   string    `json:"response_body,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// DueDelivery is a delivery claimed for sending, along with where to send it.
+// LockedUntil identifies the claim, so that an attempt is only recorded if it is still
+// held.
+type DueDelivery struct {
+	WebhookDelivery
+	URL         string
+	Secret      string
+	LockedUntil time.Time
+}
+
+type WebhookDeliveryModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns a page of a webhook's deliveries, newest first, optionally only those
+// with the given status.
+func (m WebhookDeliveryModel) GetAll(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), id, webhook_id, created_at, event_type, payload, status, attempts,
+			CASE WHEN status = 'pending' THEN next_attempt_at END, completed_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND (status = $2 OR $2 = '')
+		ORDER BY id DESC
+		LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := rows.Scan(
+			&totalRecords,
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.CreatedAt,
+			&delivery.EventType,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.CompletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return deliveries, metadata, nil
+}
+
+// Get returns one of a webhook's deliveries along with the log of attempts to send it.
+func (m WebhookDeliveryModel) Get(webhookID, id int64) (*WebhookDelivery, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, webhook_id, created_at, event_type, payload, status, attempts,
+			CASE WHEN status = 'pending' THEN next_attempt_at END, completed_at
+		FROM webhook_deliveries
+		WHERE id = $1 AND webhook_id = $2`
+
+	var delivery WebhookDelivery
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, webhookID).Scan(
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.CreatedAt,
+		&delivery.EventType,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.CompletedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+		SELECT id, attempted_at, duration_ms, coalesce(response_status, 0), response_body, error
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = $1
+		ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivery.AttemptLog = []*WebhookAttempt{}
+
+	for rows.Next() {
+		var attempt WebhookAttempt
+
+		err := rows.Scan(
+			&attempt.ID,
+			&attempt.AttemptedAt,
+			&attempt.DurationMS,
+			&attempt.ResponseStatus,
+			&attempt.ResponseBody,
+			&attempt.Error,
+		)
+		if err != nil {
+			return nil, err
+		}
+		delivery.AttemptLog = append(delivery.AttemptLog, &attempt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// Redeliver puts a delivery back in the queue to be sent straight away, with a fresh set
+// of attempts. Its attempt log is kept. A delivery which is being sent can't be
+// redelivered until the attempt finishes, and ErrDeliveryInProgress is returned. Any
+// expired claim is cleared, so a worker which overran its lease can't overwrite the reset.
+func (m WebhookDeliveryModel) Redeliver(webhookID, id int64) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), completed_at = NULL, locked_until = NULL
+		WHERE id = $1 AND webhook_id = $2 AND (locked_until IS NULL OR locked_until < NOW())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id, webhookID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	// Work out whether nothing was updated because the delivery doesn't exist, or because
+	// it is being sent.
+	var exists bool
+
+	err = m.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM webhook_deliveries WHERE id = $1 AND webhook_id = $2)", id, webhookID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrDeliveryInProgress
+	}
+
+	return ErrRecordNotFound
+}
+
+// ClaimDue claims up to limit pending deliveries which are due to be sent, to active
+// webhooks, oldest first. Claimed deliveries are locked for lease, so that other workers
+// skip them; if the worker dies, they are picked up again once the lease runs out.
+func (m WebhookDeliveryModel) ClaimDue(limit int, lease time.Duration) ([]*DueDelivery, error) {
+	query := `
+		WITH due AS (
+			SELECT d.id
+			FROM webhook_deliveries d
+			JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND w.active
+				AND (d.locked_until IS NULL OR d.locked_until < NOW())
+			ORDER BY d.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET locked_until = NOW() + make_interval(secs => $2)
+		FROM due, webhooks w
+		WHERE d.id = due.id AND w.id = d.webhook_id
+		RETURNING d.id, d.webhook_id, d.created_at, d.event_type, d.payload, d.status, d.attempts, w.url, w.secret, d.locked_until`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*DueDelivery{}
+
+	for rows.Next() {
+		var delivery DueDelivery
+
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.WebhookID,
+			&delivery.CreatedAt,
+			&delivery.EventType,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.URL,
+			&delivery.Secret,
+			&delivery.LockedUntil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RecordAttempt logs an attempt at sending a claimed delivery and releases it. If the
+// attempt succeeded the delivery is complete. Otherwise it is retried after retryAfter,
+// unless it has now had maxAttempts attempts, in which case it has failed.
+//
+// If the claim is no longer held, because it expired and the delivery was claimed again
+// or redelivered, the attempt is still logged but the delivery is left alone and
+// ErrLeaseLost is returned.
+func (m WebhookDeliveryModel) RecordAttempt(delivery *DueDelivery, attempt *WebhookAttempt, succeeded bool, maxAttempts int, retryAfter time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempted_at, duration_ms, response_status, response_body, error)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6)
+		RETURNING id`
+
+	args := []any{delivery.ID, attempt.AttemptedAt, attempt.DurationMS, attempt.ResponseStatus, attempt.ResponseBody, attempt.Error}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&attempt.ID)
+	if err != nil {
+		return err
+	}
+
+	delivery.Attempts++
+
+	switch {
+	case succeeded:
+		delivery.Status = DeliverySucceeded
+	case delivery.Attempts >= maxAttempts:
+		delivery.Status = DeliveryFailed
+	default:
+		delivery.Status = DeliveryPending
+	}
+
+	query = `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, locked_until = NULL,
+			next_attempt_at = NOW() + make_interval(secs => $3),
+			completed_at = CASE WHEN $1 = 'pending' THEN NULL ELSE NOW() END
+		WHERE id = $4 AND locked_until = $5`
+
+	args = []any{delivery.Status, delivery.Attempts, retryAfter.Seconds(), delivery.ID, delivery.LockedUntil}
+
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+// DeleteCompleted deletes deliveries, and their attempt logs, which completed more than
+// retention ago.
+func (m WebhookDeliveryModel) DeleteCompleted(retention time.Duration) error {
+	query := `
+		DELETE FROM webhook_deliveries
+		WHERE completed_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	return err
+}
@@ -0,0 +1,443 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+// documents to JSON values.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned when a patch document is malformed, such as an
+	// operation with an unknown name or a missing path.
+	ErrInvalidPatch = errors.New("invalid patch")
+
+	// ErrConflict is returned when a well-formed patch can't be applied to the
+	// document, such as removing a member which doesn't exist.
+	ErrConflict = errors.New("patch cannot be applied")
+
+	// ErrTestFailed is returned when a JSON Patch test operation doesn't match.
+	ErrTestFailed = errors.New("test operation failed")
+)
+
+// MergePatch applies the JSON Merge Patch patch to doc and returns the result. Members of
+// patch replace those in doc, a null removes the member, and objects are merged
+// recursively.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
+
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+
+	return targetObject
+}
+
+// operation is a single operation in a JSON Patch document. Path and From are pointers
+// so that a missing member can be told apart from the empty pointer to the whole
+// document.
+type operation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply applies the JSON Patch patch to doc and returns the result. The operations are
+// applied in order, and if any of them fails (including a test operation) none of them
+// take effect.
+func Apply(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []operation
+
+	err = json.Unmarshal(patch, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("%w: must be an array of operations", ErrInvalidPatch)
+	}
+
+	for i, op := range ops {
+		target, err = op.apply(target)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func (op operation) apply(doc any) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: %s must have a value", ErrInvalidPatch, op.Op)
+		}
+
+		value, err := decode(op.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			doc, _, err = remove(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			return add(doc, path, value)
+		default:
+			current, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !equal(current, value) {
+				return nil, fmt.Errorf("%w: %s", ErrTestFailed, *op.Path)
+			}
+			return doc, nil
+		}
+
+	case "remove":
+		doc, _, err = remove(doc, path)
+		return doc, err
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		var value any
+
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: cannot move %s into one of its children", ErrConflict, *op.From)
+			}
+			doc, value, err = remove(doc, from)
+		} else {
+			value, err = get(doc, from)
+			if err == nil {
+				value, err = clone(value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return add(doc, path, value)
+
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped reference tokens.
+func parsePointer(p *string) ([]string, error) {
+	if p == nil {
+		return nil, fmt.Errorf("%w: path and from must be provided", ErrInvalidPatch)
+	}
+
+	pointer := *p
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with a /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the value at path in doc.
+func get(doc any, path []string) (any, error) {
+	for i, token := range path {
+		switch container := doc.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, missing(path[:i+1])
+			}
+			doc = value
+		case []any:
+			index, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[index]
+		default:
+			return nil, missing(path[:i+1])
+		}
+	}
+
+	return doc, nil
+}
+
+// add adds value at path in doc, inserting it into an array or setting an object member,
+// and returns the updated document.
+func add(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]any:
+		container[token] = value
+		return doc, nil
+	case []any:
+		index := len(container)
+		if token != "-" {
+			index, err = arrayIndex(token, len(container))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		container = append(container, nil)
+		copy(container[index+1:], container[index:])
+		container[index] = value
+
+		return replaceParent(doc, path[:len(path)-1], container)
+	default:
+		return nil, missing(path[:len(path)-1])
+	}
+}
+
+// remove removes the value at path from doc, and returns the updated document along
+// with the removed value.
+func remove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]any:
+		value, ok := container[token]
+		if !ok {
+			return nil, nil, missing(path)
+		}
+		delete(container, token)
+		return doc, value, nil
+	case []any:
+		index, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		value := container[index]
+		container = append(container[:index], container[index+1:]...)
+
+		doc, err = replaceParent(doc, path[:len(path)-1], container)
+		return doc, value, err
+	default:
+		return nil, nil, missing(path)
+	}
+}
+
+// replaceParent stores an array which has changed length back at path, as growing or
+// shrinking a slice doesn't update the copy held by its parent.
+func replaceParent(doc any, path []string, array []any) (any, error) {
+	if len(path) == 0 {
+		return array, nil
+	}
+
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+
+	switch container := parent.(type) {
+	case map[string]any:
+		container[token] = array
+	case []any:
+		index, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = array
+	}
+
+	return doc, nil
+}
+
+// arrayIndex parses an array index from a reference token, checking that it is no more
+// than max.
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrConflict, token)
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index > max {
+		return 0, fmt.Errorf("%w: array index %s is out of range", ErrConflict, token)
+	}
+
+	return index, nil
+}
+
+func missing(path []string) error {
+	pointer := make([]string, len(path))
+	for i, token := range path {
+		pointer[i] = strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+	}
+
+	return fmt.Errorf("%w: %s does not exist", ErrConflict, "/"+strings.Join(pointer, "/"))
+}
+
+// equal compares two decoded JSON values. Numbers are compared by value, as far as
+// numberEqual can tell, and the order of object members doesn't matter.
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for name, value := range a {
+			other, ok := b[name]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		return numberEqual(a, b)
+	default:
+		return a == b
+	}
+}
+
+// numberEqual compares two JSON numbers. Integers are compared exactly, whatever their
+// size, and other numbers as float64s, so 1, 1.0 and 1e0 are equal. Numbers too large for
+// a float64 are only equal if they are written the same way. Comparing exactly in every
+// case would need arbitrary precision, and a client could make that as slow as it liked
+// with exponents like 1e9999999.
+func numberEqual(a, b json.Number) bool {
+	if isInteger(a) && isInteger(b) {
+		// JSON doesn't allow leading zeros, so -0 is the only integer with two spellings.
+		return a == b || (a == "-0" || a == "0") && (b == "-0" || b == "0")
+	}
+
+	x, errA := strconv.ParseFloat(string(a), 64)
+	y, errB := strconv.ParseFloat(string(b), 64)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return x == y
+}
+
+// isInteger reports whether a JSON number is written without a fraction or exponent.
+func isInteger(n json.Number) bool {
+	return !strings.ContainsAny(string(n), ".eE")
+}
+
+func clone(value any) (any, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return decode(js)
+}
+
+// decode reads a single JSON value, keeping numbers as json.Number so that they are
+// written back out exactly as they were read.
+func decode(js []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var value any
+
+	err := dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	if dec.More() {
+		return nil, errors.New("must contain a single JSON value")
+	}
+
+	return value, nil
+}
@@ -0,0 +1,411 @@
+package jsonpatch
+
+import (
+	"errors"
+	"testing"
+)
+
+// assertJSON fails the test unless got and want hold equal JSON values.
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	g, err := decode(got)
+	if err != nil {
+		t.Fatalf("result %s is not JSON: %v", got, err)
+	}
+	w, err := decode([]byte(want))
+	if err != nil {
+		t.Fatalf("expected %s is not JSON: %v", want, err)
+	}
+
+	if !equal(g, w) {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+// The examples from RFC 7396, Appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.doc+" "+tt.patch, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	_, err := MergePatch([]byte(`{"a":1}`), []byte(`{"a":`))
+	if !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("err = %v; want ErrInvalidPatch", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   error
+	}{
+		// RFC 6902, Appendix A.
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			want:  `{"foo":"bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			want:  `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:  `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:  "A.6 moving a value",
+			doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:  "A.7 moving an array element",
+			doc:   `{"foo":["all","grass","cows","eat"]}`,
+			patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want:  `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			name:  "A.8 testing a value: success",
+			doc:   `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			want:  `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			name:  "A.9 testing a value: error",
+			doc:   `{"baz":"qux"}`,
+			patch: `[{"op":"test","path":"/baz","value":"bar"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			want:  `{"foo":"bar","child":{"grandchild":{}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			want:  `{"foo":"bar","baz":"qux"}`,
+		},
+		{
+			name:  "A.12 adding to a nonexistent target",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			err:   ErrConflict,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":10}]`,
+			want:  `{"/":9,"~1":10}`,
+		},
+		{
+			name:  "A.15 comparing strings and numbers",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":"10"}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:  `{"foo":["bar",["abc","def"]]}`,
+		},
+
+		// Pointer escaping and array indexes.
+		{
+			name:  "~1 unescapes to /",
+			doc:   `{"a/b":1}`,
+			patch: `[{"op":"replace","path":"/a~1b","value":2}]`,
+			want:  `{"a/b":2}`,
+		},
+		{
+			name:  "~0 unescapes to ~",
+			doc:   `{"m~n":1}`,
+			patch: `[{"op":"remove","path":"/m~0n"}]`,
+			want:  `{}`,
+		},
+		{
+			name:  "adding at the end index",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"add","path":"/a/2","value":3}]`,
+			want:  `{"a":[1,2,3]}`,
+		},
+		{
+			name:  "adding past the end",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"add","path":"/a/3","value":3}]`,
+			err:   ErrConflict,
+		},
+		{
+			name:  "removing the - index",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/-"}]`,
+			err:   ErrConflict,
+		},
+		{
+			name:  "index with a leading zero",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/01"}]`,
+			err:   ErrConflict,
+		},
+		{
+			name:  "negative index",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/-1"}]`,
+			err:   ErrConflict,
+		},
+		{
+			name:  "removing past the end",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"remove","path":"/a/2"}]`,
+			err:   ErrConflict,
+		},
+
+		// Move and copy.
+		{
+			name:  "moving a value into its own child",
+			doc:   `{"a":{"b":{}}}`,
+			patch: `[{"op":"move","from":"/a","path":"/a/b/c"}]`,
+			err:   ErrConflict,
+		},
+		{
+			name:  "moving a value onto itself",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"move","from":"/a","path":"/a"}]`,
+			want:  `{"a":{"b":1}}`,
+		},
+		{
+			name:  "moving between nested arrays",
+			doc:   `{"a":[[1,2],[3]]}`,
+			patch: `[{"op":"move","from":"/a/0/0","path":"/a/1/-"}]`,
+			want:  `{"a":[[2],[3,1]]}`,
+		},
+		{
+			name:  "changing a copy leaves the original alone",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"copy","from":"/a","path":"/b"},{"op":"add","path":"/b/-","value":3},{"op":"remove","path":"/a/0"}]`,
+			want:  `{"a":[2],"b":[1,2,3]}`,
+		},
+
+		// Replacing and removing the root and nested arrays.
+		{
+			name:  "replacing the root",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"replace","path":"","value":["x"]}]`,
+			want:  `["x"]`,
+		},
+		{
+			name:  "removing the root",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"remove","path":""}]`,
+			want:  `null`,
+		},
+		{
+			name:  "replacing in a nested array",
+			doc:   `{"a":[[1,2],[3]]}`,
+			patch: `[{"op":"replace","path":"/a/0/1","value":9}]`,
+			want:  `{"a":[[1,9],[3]]}`,
+		},
+		{
+			name:  "removing from a nested array",
+			doc:   `{"a":[[1,2,3],[4]]}`,
+			patch: `[{"op":"remove","path":"/a/0/1"},{"op":"remove","path":"/a/0/0"},{"op":"test","path":"/a","value":[[3],[4]]}]`,
+			want:  `{"a":[[3],[4]]}`,
+		},
+		{
+			name:  "removing an array from an array",
+			doc:   `[[1],[2],[3]]`,
+			patch: `[{"op":"remove","path":"/1"},{"op":"add","path":"/0/-","value":0}]`,
+			want:  `[[1,0],[3]]`,
+		},
+
+		// Test compares numbers by value and objects regardless of order.
+		{
+			name:  "testing 1 against 1.0",
+			doc:   `{"n":1}`,
+			patch: `[{"op":"test","path":"/n","value":1.0},{"op":"test","path":"/n","value":1e0}]`,
+			want:  `{"n":1}`,
+		},
+		{
+			name:  "testing 1 against 1.5",
+			doc:   `{"n":1}`,
+			patch: `[{"op":"test","path":"/n","value":1.5}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "testing large integers",
+			doc:   `{"n":12345678901234567890}`,
+			patch: `[{"op":"test","path":"/n","value":12345678901234567891}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "testing a number too large for a float",
+			doc:   `{"n":1e9999999}`,
+			patch: `[{"op":"test","path":"/n","value":1e9999999},{"op":"test","path":"/n","value":2e9999999}]`,
+			err:   ErrTestFailed,
+		},
+		{
+			name:  "testing objects in a different order",
+			doc:   `{"o":{"a":1,"b":[true,null]}}`,
+			patch: `[{"op":"test","path":"/o","value":{"b":[true,null],"a":1.00}}]`,
+			want:  `{"o":{"a":1,"b":[true,null]}}`,
+		},
+		{
+			name:  "testing a missing value",
+			doc:   `{}`,
+			patch: `[{"op":"test","path":"/a","value":null}]`,
+			err:   ErrConflict,
+		},
+
+		// Malformed patches.
+		{
+			name:  "patch which isn't an array",
+			doc:   `{}`,
+			patch: `{"op":"add","path":"/a","value":1}`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "unknown operation",
+			doc:   `{}`,
+			patch: `[{"op":"append","path":"/a","value":1}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "missing path",
+			doc:   `{}`,
+			patch: `[{"op":"add","value":1}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "missing value",
+			doc:   `{}`,
+			patch: `[{"op":"add","path":"/a"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "path without a leading /",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"remove","path":"a"}]`,
+			err:   ErrInvalidPatch,
+		},
+		{
+			name:  "move without from",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"move","path":"/b"}]`,
+			err:   ErrInvalidPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v; want %v", err, tt.err)
+				}
+				if got != nil {
+					t.Errorf("got %s alongside an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+// A.13: a duplicate member makes the patch ambiguous, and it mustn't be applied as if
+// it were an add.
+func TestApplyDuplicateMember(t *testing.T) {
+	got, err := Apply([]byte(`{"foo":"bar"}`), []byte(`[{"op":"add","path":"/baz","value":"qux","op":"remove"}]`))
+	if err == nil {
+		t.Fatalf("got %s; want an error", got)
+	}
+}
+
+// A patch is applied all or nothing: if a later operation fails, the changes made by the
+// earlier ones are thrown away and the document passed in is left as it was.
+func TestApplyIsAtomic(t *testing.T) {
+	doc := []byte(`{"a":[1,2,3],"b":{"c":1}}`)
+	original := string(doc)
+
+	patch := `[
+		{"op":"remove","path":"/a/0"},
+		{"op":"add","path":"/b/d","value":2},
+		{"op":"test","path":"/b/c","value":2}
+	]`
+
+	got, err := Apply(doc, []byte(patch))
+	if !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("err = %v; want ErrTestFailed", err)
+	}
+	if got != nil {
+		t.Errorf("got %s alongside an error", got)
+	}
+	if string(doc) != original {
+		t.Errorf("doc = %s; want it unchanged as %s", doc, original)
+	}
+
+	// The same document can still be patched successfully afterwards.
+	got, err = Apply(doc, []byte(`[{"op":"test","path":"/a/0","value":1}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSON(t, got, original)
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Signer creates and checks signatures for links to stored files, so that a file can be
+// fetched without credentials until the link expires.
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret []byte) Signer {
+	return Signer{secret: secret}
+}
+
+// Sign returns the signature for key which is valid until expires.
+func (s Signer) Sign(key string, expires time.Time) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires.Unix(), 10)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is valid for key and expires, and the link has not
+// yet expired.
+func (s Signer) Verify(key string, expires time.Time, signature string) bool {
+	if time.Now().After(expires) {
+		return false
+	}
+
+	expected := s.Sign(key, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,231 @@
+// Package webhook sends signed webhook requests to subscribers' endpoints.
+//
+// Each request is a POST of a JSON body, with these headers:
+//
+//   - Webhook-Id: the id of the delivery, which stays the same when it is retried, so
+//     receivers can discard duplicates
+//   - Webhook-Event: the event type, such as movie.created
+//   - Webhook-Signature: "t=<unix time>,v1=<signature>", where the signature is the
+//     hex-encoded HMAC-SHA256, keyed with the subscription's secret, of the time, a
+//     full stop and the body
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	IDHeader        = "Webhook-Id"
+	EventHeader     = "Webhook-Event"
+	SignatureHeader = "Webhook-Signature"
+)
+
+// ErrDisallowedAddress is returned by Send when the receiver's host resolves to an
+// address which webhooks aren't allowed to reach, such as a loopback or private address.
+var ErrDisallowedAddress = errors.New("webhook receiver address is not allowed")
+
+// maxResponseBody is the most of a receiver's response body which is kept, for the
+// delivery log.
+const maxResponseBody = 1024
+
+// Message is a single webhook request.
+type Message struct {
+	ID    int64
+	Event string
+	Body  []byte
+}
+
+// Result describes how a receiver responded to a message. Status is zero if no response
+// was received.
+type Result struct {
+	Status   int
+	Body     string
+	Duration time.Duration
+}
+
+// OK reports whether the receiver accepted the message, with a 2xx status.
+func (r Result) OK() bool {
+	return r.Status >= 200 && r.Status < 300
+}
+
+// Sender sends messages to receivers.
+type Sender struct {
+	client *http.Client
+}
+
+// NewSender returns a Sender which gives up on a receiver after timeout. Redirects
+// aren't followed, so a receiver which has moved must be updated.
+//
+// Unless allowPrivate is set, the Sender refuses to connect to loopback, link-local,
+// private and other non-public addresses, so that webhooks can't be used to reach (and,
+// through the delivery log, read from) services on the server's own network. The check
+// is made on the address actually dialled, after DNS resolution, so a host name which
+// resolves to a public address when the webhook is created can't later be pointed
+// somewhere else.
+func NewSender(timeout time.Duration, allowPrivate bool) Sender {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = checkAddress
+	}
+
+	transport := &http.Transport{
+		// No proxy, as the address check would then only see the proxy's address.
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	return Sender{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// disallowedPrefixes are the non-public ranges which netip.Addr's methods don't cover.
+var disallowedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, which can reach any IPv4 address
+}
+
+// checkAddress is a net.Dialer Control function which rejects connections to addresses
+// that aren't publicly routable.
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return fmt.Errorf("%w: %s", ErrDisallowedAddress, addr)
+	}
+
+	for _, prefix := range disallowedPrefixes {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("%w: %s", ErrDisallowedAddress, addr)
+		}
+	}
+
+	return nil
+}
+
+// Send signs the message with secret and sends it to url. An error is only returned if
+// no response was received; a response with a non-2xx status is reported in the Result.
+func (s Sender) Send(ctx context.Context, url, secret string, msg Message) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(msg.Body))
+	if err != nil {
+		return Result{}, err
+	}
+
+	now := time.Now()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "greenlight-webhooks")
+	req.Header.Set(IDHeader, strconv.FormatInt(msg.ID, 10))
+	req.Header.Set(EventHeader, msg.Event)
+	req.Header.Set(SignatureHeader, Sign(secret, now, msg.Body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Result{Duration: time.Since(now)}, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+
+	return Result{
+		Status:   resp.StatusCode,
+		Body:     string(body),
+		Duration: time.Since(now),
+	}, nil
+}
+
+// Sign returns the Webhook-Signature header value for body sent at the given time.
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, signature(secret, timestamp, body))
+}
+
+// Verify reports whether header is a valid signature of body made with secret, no more
+// than tolerance ago. It is what receivers are expected to do.
+func Verify(secret, header string, body []byte, tolerance time.Duration) bool {
+	var timestamp, sig string
+
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sig == "" {
+		return false
+	}
+
+	age := time.Since(time.Unix(unix, 0))
+	if age > tolerance || age < -tolerance {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, body)))
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns how long to wait before retrying a message which has failed attempts
+// times: 30 seconds after the first failure, doubling each time up to six hours.
+func Backoff(attempts int) time.Duration {
+	const (
+		base    = 30 * time.Second
+		ceiling = 6 * time.Hour
+	)
+
+	if attempts < 1 {
+		return base
+	}
+
+	delay := base
+	for i := 1; i < attempts && delay < ceiling; i++ {
+		delay *= 2
+	}
+
+	return min(delay, ceiling)
+}
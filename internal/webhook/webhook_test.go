@@ -0,0 +1,204 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	const secret = "s3cret"
+
+	var got struct {
+		header http.Header
+		body   []byte
+	}
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.header = r.Header.Clone()
+		got.body, _ = io.ReadAll(r.Body)
+
+		if !Verify(secret, r.Header.Get(SignatureHeader), got.body, time.Minute) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	msg := Message{ID: 42, Event: "movie.created", Body: []byte(`{"event":"movie.created"}`)}
+
+	result, err := NewSender(time.Second, true).Send(context.Background(), receiver.URL, secret, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.OK() || result.Status != http.StatusNoContent {
+		t.Fatalf("status = %d (%q); want 204", result.Status, result.Body)
+	}
+	if string(got.body) != string(msg.Body) {
+		t.Errorf("body = %s; want %s", got.body, msg.Body)
+	}
+	if got.header.Get(IDHeader) != "42" {
+		t.Errorf("%s = %q; want 42", IDHeader, got.header.Get(IDHeader))
+	}
+	if got.header.Get(EventHeader) != "movie.created" {
+		t.Errorf("%s = %q; want movie.created", EventHeader, got.header.Get(EventHeader))
+	}
+	if got.header.Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q; want application/json", got.header.Get("Content-Type"))
+	}
+}
+
+func TestSendWrongSecret(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !Verify("expected", r.Header.Get(SignatureHeader), body, time.Minute) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+		}
+	}))
+	defer receiver.Close()
+
+	result, err := NewSender(time.Second, true).Send(context.Background(), receiver.URL, "other", Message{ID: 1, Body: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OK() || result.Status != http.StatusUnauthorized {
+		t.Fatalf("status = %d; want 401", result.Status)
+	}
+	if result.Body != "bad signature\n" {
+		t.Errorf("body = %q; want the receiver's response", result.Body)
+	}
+}
+
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("redirect was followed")
+	}))
+	defer target.Close()
+
+	receiver := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer receiver.Close()
+
+	result, err := NewSender(time.Second, true).Send(context.Background(), receiver.URL, "secret", Message{ID: 1, Body: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OK() {
+		t.Fatalf("status = %d; want a failure", result.Status)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer receiver.Close()
+
+	_, err := NewSender(50*time.Millisecond, true).Send(context.Background(), receiver.URL, "secret", Message{ID: 1, Body: []byte(`{}`)})
+	if err == nil {
+		t.Fatal("Send returned no error for a receiver which timed out")
+	}
+}
+
+func TestSendRejectsPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback receiver")
+	}))
+	defer receiver.Close()
+
+	_, err := NewSender(time.Second, false).Send(context.Background(), receiver.URL, "secret", Message{ID: 1, Body: []byte(`{}`)})
+	if !errors.Is(err, ErrDisallowedAddress) {
+		t.Fatalf("err = %v; want ErrDisallowedAddress", err)
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"0.0.0.0:80", false},
+		{"[::]:80", false},
+		{"10.1.2.3:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+		{"[fd00::1]:80", false},
+		{"100.64.0.1:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"[::ffff:169.254.169.254]:80", false},
+		{"[64:ff9b::a9fe:a9fe]:80", false},
+		{"224.0.0.1:80", false},
+	}
+
+	for _, tt := range tests {
+		err := checkAddress("tcp", tt.address, nil)
+		if tt.allowed && err != nil {
+			t.Errorf("checkAddress(%s) = %v; want it allowed", tt.address, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrDisallowedAddress) {
+			t.Errorf("checkAddress(%s) = %v; want ErrDisallowedAddress", tt.address, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"a":1}`)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{name: "valid", header: Sign("secret", now, body), want: true},
+		{name: "wrong secret", header: Sign("other", now, body)},
+		{name: "too old", header: Sign("secret", now.Add(-10*time.Minute), body)},
+		{name: "missing signature", header: "t=" + Sign("secret", now, body)[2:12]},
+		{name: "garbage", header: "nonsense"},
+		{name: "empty", header: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify("secret", tt.header, body, 5*time.Minute); got != tt.want {
+				t.Errorf("Verify(%q) = %v; want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+
+	if Verify("secret", Sign("secret", now, body), []byte(`{"a":2}`), time.Minute) {
+		t.Error("Verify accepted a signature for a different body")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 512 * 30 * time.Second},
+		{11, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %s; want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
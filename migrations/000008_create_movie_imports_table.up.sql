@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS movie_imports (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    completed_at timestamp(0) with time zone,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    format text NOT NULL,
+    mode text NOT NULL,
+    status text NOT NULL,
+    total_rows integer NOT NULL DEFAULT 0,
+    imported integer NOT NULL DEFAULT 0,
+    failed integer NOT NULL DEFAULT 0,
+    errors jsonb NOT NULL DEFAULT '[]'
+);
@@ -0,0 +1,5 @@
+-- The title search uses the english text search configuration, so the index must too
+-- for the planner to use it.
+DROP INDEX IF EXISTS movies_title_idx;
+
+CREATE INDEX IF NOT EXISTS movies_title_idx ON movies USING GIN (to_tsvector ('english', title));
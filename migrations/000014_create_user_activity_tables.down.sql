@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS recommendations;
+DROP TABLE IF EXISTS watch_history;
+DROP TABLE IF EXISTS watchlist;
@@ -0,0 +1,97 @@
+CREATE TABLE IF NOT EXISTS genres (
+    slug text PRIMARY KEY CHECK (slug ~ '^[a-z0-9]+(-[a-z0-9]+)*$'),
+    name text NOT NULL,
+    parent text REFERENCES genres (slug) ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS genre_aliases (
+    alias text PRIMARY KEY,
+    genre text NOT NULL REFERENCES genres (slug) ON DELETE CASCADE
+);
+
+INSERT INTO genres (slug, name, parent)
+VALUES
+    ('action', 'Action', NULL),
+    ('adventure', 'Adventure', NULL),
+    ('animation', 'Animation', NULL),
+    ('biography', 'Biography', NULL),
+    ('comedy', 'Comedy', NULL),
+    ('crime', 'Crime', NULL),
+    ('documentary', 'Documentary', NULL),
+    ('drama', 'Drama', NULL),
+    ('family', 'Family', NULL),
+    ('fantasy', 'Fantasy', NULL),
+    ('history', 'History', NULL),
+    ('horror', 'Horror', NULL),
+    ('music', 'Music', NULL),
+    ('mystery', 'Mystery', NULL),
+    ('romance', 'Romance', NULL),
+    ('science-fiction', 'Science Fiction', NULL),
+    ('sport', 'Sport', NULL),
+    ('thriller', 'Thriller', NULL),
+    ('war', 'War', NULL),
+    ('western', 'Western', NULL)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO genres (slug, name, parent)
+VALUES
+    ('musical', 'Musical', 'music'),
+    ('romantic-comedy', 'Romantic Comedy', 'comedy'),
+    ('superhero', 'Superhero', 'action'),
+    ('film-noir', 'Film Noir', 'crime'),
+    ('psychological-thriller', 'Psychological Thriller', 'thriller'),
+    ('space-opera', 'Space Opera', 'science-fiction')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO genre_aliases (alias, genre)
+VALUES
+    ('sci-fi', 'science-fiction'),
+    ('scifi', 'science-fiction'),
+    ('sf', 'science-fiction'),
+    ('rom-com', 'romantic-comedy'),
+    ('romcom', 'romantic-comedy'),
+    ('animated', 'animation'),
+    ('cartoon', 'animation'),
+    ('biopic', 'biography'),
+    ('doc', 'documentary'),
+    ('docs', 'documentary'),
+    ('sports', 'sport'),
+    ('historical', 'history'),
+    ('noir', 'film-noir'),
+    ('romantic', 'romance'),
+    ('scary', 'horror'),
+    ('comic-book', 'superhero')
+ON CONFLICT DO NOTHING;
+
+-- Genres already in use which don't match a known genre or alias become genres of
+-- their own, so that no movie loses a genre.
+INSERT INTO genres (slug, name)
+SELECT DISTINCT s.slug, initcap(replace(s.slug, '-', ' '))
+FROM movies
+CROSS JOIN unnest(genres) AS g(name)
+CROSS JOIN LATERAL (SELECT trim(both '-' from regexp_replace(lower(g.name), '[^a-z0-9]+', '-', 'g')) AS slug) s
+WHERE s.slug <> ''
+AND NOT EXISTS (SELECT 1 FROM genres WHERE genres.slug = s.slug)
+AND NOT EXISTS (SELECT 1 FROM genre_aliases WHERE genre_aliases.alias = s.slug);
+
+-- Replace every genre on every movie with its canonical slug, dropping duplicates which
+-- this creates and keeping the genres in their original order. Movie versions are left
+-- alone: this doesn't change what the movies are, only how their genres are spelled.
+UPDATE movies
+SET genres = n.genres
+FROM (
+    SELECT id, array_agg(slug ORDER BY pos) AS genres
+    FROM (
+        SELECT movies.id, coalesce(genre_aliases.genre, s.slug) AS slug, min(g.pos) AS pos
+        FROM movies
+        CROSS JOIN unnest(movies.genres) WITH ORDINALITY AS g(name, pos)
+        CROSS JOIN LATERAL (SELECT trim(both '-' from regexp_replace(lower(g.name), '[^a-z0-9]+', '-', 'g')) AS slug) s
+        LEFT JOIN genre_aliases ON genre_aliases.alias = s.slug
+        WHERE s.slug <> ''
+        GROUP BY movies.id, coalesce(genre_aliases.genre, s.slug)
+    ) canonical
+    GROUP BY id
+) n
+WHERE movies.id = n.id AND movies.genres IS DISTINCT FROM n.genres;
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS movie_translations (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    language text NOT NULL,
+    title text NOT NULL,
+    synopsis text NOT NULL DEFAULT '',
+    config regconfig NOT NULL DEFAULT 'simple',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(6) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, language)
+);
+
+CREATE INDEX IF NOT EXISTS movie_translations_title_idx ON movie_translations USING GIN (to_tsvector(config, title));
+
+CREATE TABLE IF NOT EXISTS movie_releases (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    country char(2) NOT NULL,
+    release_date date NOT NULL,
+    certification text NOT NULL DEFAULT '',
+    PRIMARY KEY (movie_id, country)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_normalised_title_idx;
+DROP TABLE IF EXISTS movie_redirects;
+DROP TABLE IF EXISTS movie_external_ids;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS movie_external_ids (
+    source text NOT NULL,
+    external_id text NOT NULL,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    PRIMARY KEY (source, external_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_external_ids_movie_id_idx ON movie_external_ids (movie_id);
+
+-- When a duplicate movie is merged into another, its id is kept here so that requests
+-- for it can be redirected.
+CREATE TABLE IF NOT EXISTS movie_redirects (
+    old_id bigint PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS movie_redirects_movie_id_idx ON movie_redirects (movie_id);
+
+-- Movies are reported as possible duplicates when their titles match after case and
+-- punctuation are ignored, and they are from the same year.
+CREATE INDEX IF NOT EXISTS movies_normalised_title_idx ON movies (lower(regexp_replace(title, '[^[:alnum:]]+', '', 'g')), year);
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+-- A movie can belong to several collections, e.g. a trilogy and the franchise it is part of.
+CREATE TABLE IF NOT EXISTS collection_movies (
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    PRIMARY KEY (collection_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS collection_movies_movie_id_idx ON collection_movies (movie_id);
+
+CREATE TABLE IF NOT EXISTS movie_relations (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    related_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    kind text NOT NULL CHECK (kind IN ('sequel_of', 'remake_of', 'spin_off_of')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, related_id),
+    CHECK (movie_id <> related_id)
+);
+
+-- Two movies can only be related one way round: a movie can't be both the sequel and
+-- the original of another.
+CREATE UNIQUE INDEX IF NOT EXISTS movie_relations_pair_idx ON movie_relations (least(movie_id, related_id), greatest(movie_id, related_id));
+CREATE INDEX IF NOT EXISTS movie_relations_related_id_idx ON movie_relations (related_id);
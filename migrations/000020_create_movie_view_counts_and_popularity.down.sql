@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_popularity_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS popularity;
+DROP TABLE IF EXISTS movie_view_counts;
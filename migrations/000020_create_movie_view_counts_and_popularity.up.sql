@@ -0,0 +1,14 @@
+-- Views are counted per movie per hour. The counts are estimates, since only a sample of
+-- views is recorded and each one counts for the views it stands in for.
+CREATE TABLE IF NOT EXISTS movie_view_counts (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    bucket timestamp(0) with time zone NOT NULL,
+    views double precision NOT NULL,
+    PRIMARY KEY (movie_id, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS movie_view_counts_bucket_idx ON movie_view_counts (bucket);
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS popularity double precision NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS movies_popularity_idx ON movies (popularity, id);
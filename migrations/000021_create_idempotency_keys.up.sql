@@ -0,0 +1,18 @@
+-- Responses to POST requests sent with an Idempotency-Key header, so that a retried
+-- request gets the original response instead of being processed again. Anonymous
+-- requests, such as registering a user, are stored with a user_id of 0. A row with no
+-- completed_at belongs to a request which is still being processed.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id bigint NOT NULL,
+    endpoint text NOT NULL,
+    key text NOT NULL,
+    fingerprint bytea NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    completed_at timestamp(0) with time zone,
+    status integer,
+    headers jsonb,
+    body bytea,
+    PRIMARY KEY (user_id, endpoint, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
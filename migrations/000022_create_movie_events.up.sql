@@ -0,0 +1,19 @@
+-- A log of changes to movies, streamed to clients watching GET /v1/movies/events. There is
+-- no foreign key on movie_id, as the events for a movie outlive its deletion.
+--
+-- Events are inserted by the transaction making the change, in whatever order those
+-- transactions happen to commit. Once committed, each event is given the next position
+-- in the log, and streams read in position order, so a stream which has read up to a
+-- position never misses an event which committed later. Events without a position yet
+-- are not visible to streams.
+CREATE TABLE IF NOT EXISTS movie_events (
+    id bigserial PRIMARY KEY,
+    position bigint UNIQUE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind text NOT NULL CHECK (kind IN ('created', 'updated', 'deleted')),
+    movie_id bigint NOT NULL,
+    version integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS movie_events_created_at_idx ON movie_events (created_at);
+CREATE INDEX IF NOT EXISTS movie_events_unpositioned_idx ON movie_events (id) WHERE position IS NULL;